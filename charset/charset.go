@@ -0,0 +1,287 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package charset converts UTF-16 and Latin-1 text to UTF-8,
+// so that the indexer and grep, which work only with UTF-8,
+// can search files in those encodings.
+package charset
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/google/codesearch/glob"
+)
+
+// An Encoding is a text encoding.
+type Encoding int
+
+const (
+	UTF8 Encoding = iota
+	UTF16LE
+	UTF16BE
+	Latin1
+)
+
+var names = map[string]Encoding{
+	"utf-8":      UTF8,
+	"utf8":       UTF8,
+	"utf-16le":   UTF16LE,
+	"utf16le":    UTF16LE,
+	"utf-16be":   UTF16BE,
+	"utf16be":    UTF16BE,
+	"latin1":     Latin1,
+	"latin-1":    Latin1,
+	"iso-8859-1": Latin1,
+}
+
+// Lookup returns the encoding with the given name,
+// such as utf-8, utf-16le, utf-16be, or latin1.
+// Case is ignored.
+func Lookup(name string) (Encoding, error) {
+	e, ok := names[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown encoding %q", name)
+	}
+	return e, nil
+}
+
+func (e Encoding) String() string {
+	switch e {
+	case UTF8:
+		return "utf-8"
+	case UTF16LE:
+		return "utf-16le"
+	case UTF16BE:
+		return "utf-16be"
+	case Latin1:
+		return "latin1"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// Sniff reports the encoding named by the byte order mark
+// at the start of b, if any, and the length of the mark.
+func Sniff(b []byte) (e Encoding, n int, ok bool) {
+	switch {
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		return UTF8, 3, true
+	case len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE:
+		return UTF16LE, 2, true
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		return UTF16BE, 2, true
+	}
+	return UTF8, 0, false
+}
+
+// NewReader returns a reader that converts the text read from r to UTF-8.
+// If the text begins with a byte order mark, the reader uses the
+// encoding it names and drops the mark; otherwise it uses enc.
+// Malformed input becomes U+FFFD. Offsets in the converted text
+// therefore differ from those in the original, even for UTF-8:
+// dropping a UTF-8 mark moves everything after it back 3 bytes.
+func NewReader(r io.Reader, enc Encoding) io.Reader {
+	return &reader{r: r, enc: enc}
+}
+
+type reader struct {
+	r       io.Reader
+	enc     Encoding
+	sniffed bool
+	err     error  // error from r, returned once in is converted
+	in      []byte // input not yet converted
+	out     []byte // output not yet returned
+	inbuf   []byte // backing for in
+	outbuf  []byte // backing for out
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	if !d.sniffed {
+		d.sniffed = true
+		head := p
+		if len(head) < 3 {
+			head = make([]byte, 3)
+		}
+		n, err := io.ReadAtLeast(d.r, head, 3)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		b := head[:n]
+		if e, m, ok := Sniff(b); ok {
+			d.enc = e
+			b = b[m:]
+		}
+		d.err = err
+		if d.enc == UTF8 && len(b) <= len(p) {
+			// Most files are UTF-8 without a byte order mark,
+			// and for them the reader just passes data through.
+			if n := copy(p, b); n > 0 {
+				return n, nil
+			}
+		} else {
+			d.in = append(d.inbuf[:0], b...)
+		}
+	}
+	for len(d.out) == 0 {
+		if len(d.in) == 0 {
+			if d.err != nil {
+				return 0, d.err
+			}
+			if d.enc == UTF8 {
+				return d.r.Read(p)
+			}
+		}
+		d.convert()
+		if len(d.out) == 0 {
+			d.fill()
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// fill reads more input after the partial code unit left in d.in.
+func (d *reader) fill() {
+	if d.err != nil {
+		return
+	}
+	if d.inbuf == nil {
+		d.inbuf = make([]byte, 4096)
+	}
+	buf := d.inbuf[:cap(d.inbuf)]
+	n := copy(buf, d.in)
+	m, err := d.r.Read(buf[n:])
+	d.in = buf[:n+m]
+	d.err = err
+}
+
+// convert converts as much of d.in as it can to d.out.
+// It leaves an incomplete code unit sequence in d.in
+// unless there is no more input to come.
+func (d *reader) convert() {
+	out := d.outbuf[:0]
+	in := d.in
+	switch d.enc {
+	case UTF8:
+		out = append(out, in...)
+		in = nil
+	case Latin1:
+		for _, c := range in {
+			out = utf8.AppendRune(out, rune(c))
+		}
+		in = nil
+	case UTF16LE, UTF16BE:
+		for len(in) >= 2 {
+			u := d.unit(in)
+			if utf16.IsSurrogate(rune(u)) {
+				if len(in) < 4 {
+					if d.err == nil {
+						break
+					}
+					out = utf8.AppendRune(out, utf8.RuneError)
+					in = in[2:]
+					continue
+				}
+				r := utf16.DecodeRune(rune(u), rune(d.unit(in[2:])))
+				if r != utf8.RuneError {
+					out = utf8.AppendRune(out, r)
+					in = in[4:]
+					continue
+				}
+				// Unpaired surrogate.
+				out = utf8.AppendRune(out, utf8.RuneError)
+				in = in[2:]
+				continue
+			}
+			out = utf8.AppendRune(out, rune(u))
+			in = in[2:]
+		}
+		if len(in) == 1 && d.err != nil {
+			out = utf8.AppendRune(out, utf8.RuneError)
+			in = nil
+		}
+	}
+	d.in = in
+	d.out = out
+	d.outbuf = out[:0]
+}
+
+func (d *reader) unit(b []byte) uint16 {
+	if d.enc == UTF16LE {
+		return uint16(b[0]) | uint16(b[1])<<8
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// A Map chooses the encoding of files by name.
+// Its zero value assumes every file is UTF-8.
+type Map struct {
+	rules []rule
+}
+
+type rule struct {
+	pattern string
+	enc     Encoding
+}
+
+// AddFlags adds the repeatable -encoding flag to the default flag set.
+func (m *Map) AddFlags() {
+	flag.Func("encoding", "read files with names matching `glob=enc` in encoding enc (repeatable)", m.Set)
+}
+
+// Set adds a rule of the form glob=enc, such as *.rc=utf-16le,
+// saying that files whose names match glob use encoding enc.
+// Globs are matched as in the csearch -exclude flag,
+// against runs of elements in the file name (see package glob).
+// When several rules match, the last one wins.
+func (m *Map) Set(s string) error {
+	pattern, name, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("malformed encoding rule %q: want glob=encoding", s)
+	}
+	pattern, err := glob.Clean(pattern)
+	if err != nil {
+		return err
+	}
+	enc, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	m.rules = append(m.rules, rule{pattern, enc})
+	return nil
+}
+
+// Rules returns the rules added by Set, in order, as glob=enc,
+// with the glob cleaned and the encoding given its canonical name.
+// A nil Map has no rules.
+func (m *Map) Rules() []string {
+	if m == nil {
+		return nil
+	}
+	var rules []string
+	for _, r := range m.rules {
+		rules = append(rules, r.pattern+"="+r.enc.String())
+	}
+	return rules
+}
+
+// Lookup returns the encoding to assume for the named file
+// when it has no byte order mark. A nil Map returns UTF8.
+func (m *Map) Lookup(name string) Encoding {
+	if m == nil || len(m.rules) == 0 {
+		return UTF8
+	}
+	elem := glob.Split(name)
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		if r := m.rules[i]; glob.Match(r.pattern, elem) {
+			return r.enc
+		}
+	}
+	return UTF8
+}
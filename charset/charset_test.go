@@ -0,0 +1,102 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package charset
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+var readerTests = []struct {
+	enc Encoding
+	in  string
+	out string
+}{
+	{UTF8, "hello\n", "hello\n"},
+	{UTF8, "\xEF\xBB\xBFhello", "hello"},
+	{UTF8, "\xFF\xFEh\x00i\x00", "hi"},
+	{UTF8, "\xFE\xFF\x00h\x00i", "hi"},
+	{UTF16LE, "h\x00\xE9\x00\n\x00", "hé\n"},
+	{UTF16BE, "\x00h\x00\xE9\x00\n", "hé\n"},
+	{UTF16LE, "=\xD8\x00\xDE", "😀"},
+	{UTF16LE, "=\xD8x\x00", "�x"},
+	{UTF16LE, "=\xD8", "�"},
+	{UTF16LE, "x\x00y", "x�"},
+	{UTF16BE, "\xEF\xBB\xBFa", "a"},
+	{Latin1, "caf\xE9\n", "café\n"},
+	{Latin1, "\xFF\xFEc\x00\xE9\x00", "cé"},
+}
+
+func TestReader(t *testing.T) {
+	for _, tt := range readerTests {
+		for _, one := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.in)
+			if one {
+				r = iotest.OneByteReader(r)
+			}
+			out, err := io.ReadAll(NewReader(r, tt.enc))
+			if err != nil || string(out) != tt.out {
+				t.Errorf("NewReader(%q, %v) = %q, %v, want %q", tt.in, tt.enc, out, err, tt.out)
+			}
+		}
+	}
+}
+
+func TestReaderLarge(t *testing.T) {
+	// Check conversion across buffer boundaries.
+	want := strings.Repeat("línea 😀 de texto\n", 1000)
+	in := []byte("\xFF\xFE")
+	for _, u := range utf16.Encode([]rune(want)) {
+		in = append(in, byte(u), byte(u>>8))
+	}
+	out, err := io.ReadAll(NewReader(bytes.NewReader(in), UTF8))
+	if err != nil || string(out) != want {
+		t.Errorf("NewReader(large UTF-16LE) = %d bytes, %v, want %d bytes", len(out), err, len(want))
+	}
+}
+
+func TestMap(t *testing.T) {
+	var m Map
+	for _, s := range []string{"*.rc=utf-16le", "legacy=latin1", "legacy/new/*=UTF-8", "*.txt=utf-16be"} {
+		if err := m.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		enc  Encoding
+	}{
+		{"/src/app/res.rc", UTF16LE},
+		{"/src/legacy/x.c", Latin1},
+		{"/src/legacy/new/x.c", UTF8},
+		{"/src/legacy/new/x.txt", UTF16BE},
+		{"/src/app.zip\x01res/app.rc", UTF16LE},
+		{"/src/app/x.go", UTF8},
+	}
+	for _, tt := range tests {
+		if enc := m.Lookup(tt.name); enc != tt.enc {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.name, enc, tt.enc)
+		}
+	}
+
+	for _, s := range []string{"*.rc", "*.rc=ebcdic", "[=latin1"} {
+		if err := m.Set(s); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", s)
+		}
+	}
+	want := []string{"*.rc=utf-16le", "legacy=latin1", "legacy/new/*=utf-8", "*.txt=utf-16be"}
+	if rules := m.Rules(); !slices.Equal(rules, want) {
+		t.Errorf("Rules() = %q, want %q", rules, want)
+	}
+	var nilMap *Map
+	if enc := nilMap.Lookup("x.rc"); enc != UTF8 || nilMap.Rules() != nil {
+		t.Errorf("nil Map Lookup = %v, Rules = %q, want utf-8 and no rules", enc, nilMap.Rules())
+	}
+}
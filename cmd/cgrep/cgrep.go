@@ -11,16 +11,75 @@ import (
 	"os"
 	"runtime/pprof"
 
+	"github.com/google/codesearch/charset"
 	"github.com/google/codesearch/regexp"
 )
 
-var usageMessage = `usage: cgrep [-c] [-h] [-i] [-l] [-n] [-v] regexp [file...]
+var usageMessage = `usage: cgrep [-c] [-h] [-i] [-l] [-n] [-o] [-v] [-A n] [-B n] [-C n] [-compact] [-column] [-vimgrep] [-column-unit unit] [-max-columns n] [-m n] [-max-total n] [-stats] [-U] [-json] [-color when] [-encoding glob=enc] [-exclude glob] [-exclude-regexp fileregexp] regexp [file...]
 
 Cgrep behaves like grep, searching for regexp, an RE2 (nearly PCRE) regular expression.
 
-The -c, -h, -i, -l, -n, and -v flags are as in grep, although note that as per Go's
+The -c, -h, -i, -l, -n, -o, and -v flags are as in grep, although note that as per Go's
 flag parsing convention, they cannot be combined: the option pair -i -n
 cannot be abbreviated to -in.
+
+The -A, -B, and -C flags print n lines of context after, before, or
+around each matching line, as in grep, with -- between groups of lines
+that are not adjacent. The -compact flag prints context in an older,
+more compact form instead: a name:lineno: header for each match,
+followed by the matching line, marked with >>, and its context,
+all indented by a tab after removing their common indentation.
+
+The -column flag prints the column of the first match on each line
+after its line number, and the -vimgrep flag prints every match on a line
+of its own, as path:lineno:column:text, the form that editors such as vim
+and emacs read as a list of locations. Columns count bytes from 1, or
+runes with -column-unit runes. With either flag, file names inside the
+current directory are printed relative to it. With -U, these flags print
+each line of a match once, with the column of the first match on it.
+
+The -max-columns flag prints at most n bytes of each line, for files
+with very long lines, such as minified JavaScript. A longer line is cut
+down to a part beginning shortly before its first match, with notes
+saying how many bytes were left out. Lines of any length are searched
+in full, whatever the flag.
+
+The -m flag stops reading each file after n matches, and the
+-max-total flag stops the search after n matches in all. If either limit
+cuts the results short, a note saying so is printed to standard error
+and, with -json, recorded in the output: the summary says why -max-total
+stopped the search, and the end object of each file cut short by -m
+says "limited":true.
+
+The -U flag enables multiline matching: \n in regexp matches a newline,
+and with the s flag, as in (?s), so does dot, letting a match span lines.
+Cgrep prints every line of each match.
+
+The -json flag causes cgrep to print its results as JSON Lines,
+using the same schema as ripgrep's --json flag. It cannot be combined
+with -c or -l.
+
+The -color flag highlights file names, line numbers, and matching text
+using ANSI escapes. When is auto, always, or never; auto, the default,
+highlights output only when it goes to a terminal and the NO_COLOR
+environment variable is unset or empty. The CSEARCH_COLORS environment
+variable changes the colors: it is a colon-separated list of settings
+such as path=35, line=32, match=1;31 (the defaults), or sep=36,
+each giving the SGR parameters for one part of the output.
+
+Files that begin with a UTF-16 byte order mark are converted to UTF-8
+before searching. The -encoding flag names the encoding of other files
+whose names match glob: utf-8, utf-16le, utf-16be, or latin1.
+For example, -encoding '*.rc=utf-16le'.
+
+The -stats flag prints statistics about the search to standard error
+when it finishes: the files and bytes read, the files that could not be
+opened, the files that matched and the number of matches, and the time
+taken. With -json, the statistics also appear in the final summary
+object, as "search".
+
+The -exclude and -exclude-regexp flags skip named files matching the
+glob pattern glob or the RE2 regular expression fileregexp, as in csearch.
 `
 
 func usage() {
@@ -30,6 +89,7 @@ func usage() {
 
 var (
 	iflag      = flag.Bool("i", false, "case-insensitive match")
+	uflag      = flag.Bool("U", false, "allow matches to span lines")
 	cpuProfile = flag.String("cpuprofile", "", "write cpu profile to this file")
 )
 
@@ -38,6 +98,11 @@ func main() {
 	var g regexp.Grep
 	g.AddFlags()
 	g.AddVFlag()
+	var exclude regexp.Exclude
+	exclude.AddFlags()
+	var encodings charset.Map
+	encodings.AddFlags()
+	g.Encodings = &encodings
 	g.Stdout = os.Stdout
 	g.Stderr = os.Stderr
 	flag.Usage = usage
@@ -46,6 +111,16 @@ func main() {
 	if len(args) == 0 {
 		flag.Usage()
 	}
+	if g.JSON && (g.C || g.L) {
+		log.Fatal("-json cannot be combined with -c or -l")
+	}
+	if err := g.InitColor(); err != nil {
+		log.Fatal(err)
+	}
+	if g.Column || g.Vimgrep {
+		// Editors want names relative to the current directory.
+		g.Dir, _ = os.Getwd()
+	}
 
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
@@ -61,18 +136,35 @@ func main() {
 	if *iflag {
 		pat = "(?i)" + pat
 	}
-	re, err := regexp.Compile(pat)
+	compile := regexp.Compile
+	if *uflag {
+		compile = regexp.CompileMultiline
+	}
+	re, err := compile(pat)
 	if err != nil {
 		log.Fatal(err)
 	}
 	g.Regexp = re
+	if g.Stats != nil {
+		g.Stats.EndPhase("parse")
+	}
 	if len(args) == 1 {
 		g.Reader(os.Stdin, "<standard input>")
 	} else {
 		for _, arg := range args[1:] {
+			if g.Limited {
+				break
+			}
+			if exclude.Match(arg) {
+				continue
+			}
 			g.File(arg)
 		}
 	}
+	if g.Stats != nil {
+		g.Stats.EndPhase("search")
+	}
+	g.Finish()
 	if !g.Match {
 		os.Exit(1)
 	}
@@ -13,10 +13,11 @@ import (
 	"runtime/pprof"
 	"slices"
 
+	"github.com/google/codesearch/charset"
 	"github.com/google/codesearch/index"
 )
 
-var usageMessage = `usage: cindex [-list] [-reset] [-zip] [path...]
+var usageMessage = `usage: cindex [-list] [-reset] [-zip] [-encoding glob=enc] [path...]
 
 Cindex prepares the trigram index for use by csearch.  The index is the
 file named by $CSEARCHINDEX, or else $HOME/.csearchindex.
@@ -45,6 +46,17 @@ The -zip flag causes cindex to index content inside ZIP files.
 This feature is experimental and will almost certainly change
 in the future, possibly in incompatible ways.
 
+Cindex indexes only text files. Files that begin with a UTF-16 byte order
+mark are converted to UTF-8 for indexing. The -encoding flag names the
+encoding of other files whose names match glob, as in csearch -exclude:
+for example, -encoding '*.rc=utf-16le' or -encoding 'legacy/*.c=latin1'.
+The encodings are utf-8, utf-16le, utf-16be, and latin1. The flag can be
+repeated; the last matching rule wins. The rules are recorded in the
+index, so that csearch and csweb convert files the same way, and a later
+cindex run without -encoding flags, such as a nightly update, uses them
+again. Rules given to a later run add to those recorded, taking
+precedence over them; use -reset to discard them.
+
 By default cindex adds the named paths to the index but preserves
 information about other paths that might already be indexed
 (the ones printed by cindex -list).  The -reset flag causes cindex to
@@ -69,6 +81,8 @@ var (
 
 func main() {
 	log.SetPrefix("cindex: ")
+	var encodings charset.Map
+	encodings.AddFlags()
 	flag.Usage = usage
 	flag.Parse()
 
@@ -122,20 +136,53 @@ func main() {
 		// Does not exist.
 		*resetFlag = true
 	}
-	file := master
-	if !*resetFlag {
-		file += "~"
+	update(master, roots, &encodings, *resetFlag)
+
+	log.Printf("done")
+
+	if *statsFlag {
+		ix := index.Open(master)
+		ix.PrintStats()
+	}
+	return
+}
+
+// update indexes the files in roots, adding them to the index in master,
+// or replacing it if reset is set. Files without byte order marks are
+// converted to UTF-8 as encodings says.
+func update(master string, roots []index.Path, encodings *charset.Map, reset bool) {
+	// Write the new index to a temporary file and rename it into place
+	// when done, so that programs with the old index open can keep using it.
+	file := master + "~"
+	if !reset {
 		if *checkFlag {
 			ix := index.Open(master)
 			if err := ix.Check(); err != nil {
 				log.Fatal(err)
 			}
 		}
+
+		// Start from the rules recorded in the index, so that the files
+		// indexed with them are converted the same way again.
+		// Rules given as flags follow them and so take precedence.
+		old := index.Open(master)
+		var m charset.Map
+		for _, r := range old.Encodings().Rules() {
+			if !slices.Contains(encodings.Rules(), r) {
+				m.Set(r)
+			}
+		}
+		old.Close()
+		for _, r := range encodings.Rules() {
+			m.Set(r)
+		}
+		encodings = &m
 	}
 
 	ix := index.Create(file)
 	ix.Verbose = *verboseFlag
 	ix.Zip = *zipFlag
+	ix.Encodings = encodings
 	ix.AddRoots(roots)
 	for _, root := range roots {
 		log.Printf("index %s", root)
@@ -165,7 +212,7 @@ func main() {
 	log.Printf("flush index")
 	ix.Flush()
 
-	if !*resetFlag {
+	if !reset {
 		log.Printf("merge %s %s", master, file)
 		index.Merge(file+"~", master, file)
 		if *checkFlag {
@@ -183,13 +230,6 @@ func main() {
 				log.Fatal(err)
 			}
 		}
+		os.Rename(file, master)
 	}
-
-	log.Printf("done")
-
-	if *statsFlag {
-		ix := index.Open(master)
-		ix.PrintStats()
-	}
-	return
 }
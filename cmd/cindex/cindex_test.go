@@ -0,0 +1,73 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"slices"
+	"testing"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/index"
+)
+
+func TestUpdateEncodings(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.rc":  "h\x00e\x00l\x00l\x00o\x00\n\x00", // UTF-16LE: "hello\n"
+		"b.txt": "caf\xe9\n",                       // Latin-1: "café\n"
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots := []index.Path{index.MakePath(dir)}
+	master := filepath.Join(t.TempDir(), "index")
+
+	// The second run gives only the rule for b.txt,
+	// but a.rc must still be converted by the recorded rule.
+	for _, tt := range []struct {
+		rule  string
+		reset bool
+	}{
+		{"*.rc=utf-16le", true},
+		{"*.txt=latin1", false},
+		{"*.txt=latin1", false},
+	} {
+		var encodings charset.Map
+		if err := encodings.Set(tt.rule); err != nil {
+			t.Fatal(err)
+		}
+		update(master, roots, &encodings, tt.reset)
+	}
+
+	ix := index.Open(master)
+	defer ix.Close()
+	if got, want := ix.Encodings().Rules(), []string{"*.rc=utf-16le", "*.txt=latin1"}; !slices.Equal(got, want) {
+		t.Errorf("Encodings().Rules() = %q, want %q", got, want)
+	}
+	for _, tt := range []struct {
+		re   string
+		name string
+	}{
+		{"hello", "a.rc"},
+		{"café", "b.txt"},
+	} {
+		re, err := syntax.Parse(tt.re, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fileid := range ix.PostingQuery(index.RegexpQuery(re)) {
+			names = append(names, filepath.Base(ix.Name(fileid).String()))
+		}
+		if !slices.Equal(names, []string{tt.name}) {
+			t.Errorf("files containing %q = %q, want [%q]", tt.re, names, tt.name)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// chownLike does nothing: files have no Unix owner and group here.
+func chownLike(f *os.File, fi fs.FileInfo) {}
@@ -0,0 +1,28 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of the file described by fi,
+// if it can. Only the superuser can give a file away, so the owner is
+// usually kept only when it is already the same, and the group only
+// when the user is a member of it; csearch replaces the file anyway.
+func chownLike(f *os.File, fi fs.FileInfo) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if f.Chown(int(st.Uid), int(st.Gid)) != nil {
+		// Keep at least the group, if that is allowed.
+		f.Chown(-1, int(st.Gid))
+	}
+}
@@ -5,31 +5,193 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
-	"strings"
 
+	"github.com/google/codesearch/charset"
 	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/query"
 	"github.com/google/codesearch/regexp"
 )
 
-var usageMessage = `usage: csearch [-c] [-f fileregexp] [-h] [-i] [-l] [-n] regexp
+var usageMessage = `usage: csearch [-c] [-o] [-f fileregexp] [-exclude glob] [-exclude-regexp fileregexp] [-h] [-i] [-l] [-n] [-root dir] [-query] [-U] [-fuzzy k] [-json] [-color when] [-encoding glob=enc]
+	[-A n] [-B n] [-C n] [-compact] [-column] [-vimgrep] [-column-unit unit] [-max-columns n]
+	[-m n] [-max-total n] [-rank n] [-replace template] [-write] [-stats] [-max-files n] [-max-bytes n] [-timeout d] regexp
+       csearch -serve-stdio [-encoding glob=enc]
 
 Csearch behaves like grep over all indexed files, searching for regexp,
 an RE2 (nearly PCRE) regular expression.
 
-The -c, -h, -i, -l, and -n flags are as in grep, although note that as per Go's
+The -c, -h, -i, -l, -n, and -o flags are as in grep, although note that as per Go's
 flag parsing convention, they cannot be combined: the option pair -i -n
 cannot be abbreviated to -in.
 
+The -A, -B, and -C flags print n lines of context after, before, or
+around each matching line, as in grep, with -- between groups of lines
+that are not adjacent. The -compact flag prints context in an older,
+more compact form instead: a name:lineno: header for each match,
+followed by the matching line, marked with >>, and its context,
+all indented by a tab after removing their common indentation.
+
+The -column flag prints the column of the first match on each line
+after its line number, and the -vimgrep flag prints every match on a line
+of its own, as path:lineno:column:text, the form that editors such as vim
+and emacs read as a list of locations. Columns count bytes from 1, or
+runes with -column-unit runes. With either flag, file names inside the
+current directory are printed relative to it. With -U, these flags print
+each line of a match once, with the column of the first match on it.
+
+The -max-columns flag prints at most n bytes of each line, for files
+with very long lines, such as minified JavaScript. A longer line is cut
+down to a part beginning shortly before its first match, with notes
+saying how many bytes were left out. Lines of any length are searched
+in full, whatever the flag.
+
+The -m flag stops reading each file after n matches, and the
+-max-total flag stops the search after n matches in all. If either limit
+cuts the results short, a note saying so is printed to standard error
+and, with -json, recorded in the output: the summary says why -max-total
+stopped the search, and the end object of each file cut short by -m
+says "limited":true.
+
 The -f flag restricts the search to files whose names match the RE2 regular
 expression fileregexp.
 
+The -root flag restricts the search to files in the directory tree rooted
+at dir. It can be repeated to search several trees, and -dir is a synonym.
+Because the index lists files in path order, the files in a tree are
+found by binary search, and searching a small tree in a large index
+reads only the candidate files in that tree.
+
+The -exclude and -exclude-regexp flags skip files whose names match the
+glob pattern glob or the RE2 regular expression fileregexp. Both can be
+repeated.
+A glob is matched against each element of the file name, or each run of
+elements if the glob contains slashes, so that -exclude '*_test.go'
+skips Go test files and -exclude vendor skips all vendor directories.
+
+The -U flag enables multiline matching: \n in regexp matches a newline,
+and with the s flag, as in (?s), so does dot, letting a match span lines.
+Csearch prints every line of each match. The -U flag ignores context.
+With -query, every content term is matched this way.
+
+The -fuzzy flag causes csearch to interpret its argument as a literal
+string instead of a regular expression and to print the lines containing
+text within Levenshtein distance k of it: text that can be made into the
+literal by inserting, deleting, or substituting at most k characters.
+For example, 'csearch -fuzzy 2 Recieve' finds both Recieve and Receive.
+The literal must be longer than k characters, and the longer it is,
+the better the index can narrow the search. The -fuzzy flag cannot be
+combined with -i, -o, -U, or -query.
+
+The -json flag causes csearch to print its results as JSON Lines,
+one object per line, using the same schema as ripgrep's --json flag:
+a "begin" object for each file with matches, a "match" object for each
+matching line (with its line number, byte offset, and the matching text),
+"context" objects for the lines printed by -A, -B, and -C, an "end" object
+for each file, and a final "summary" object. The -json flag cannot be
+combined with -c or -l.
+
+The -color flag highlights file names, line numbers, and matching text
+using ANSI escapes, as in cgrep. When is auto, always, or never; auto,
+the default, highlights output only when it goes to a terminal and the
+NO_COLOR environment variable is unset or empty. The CSEARCH_COLORS
+environment variable changes the colors: it is a colon-separated list
+of settings such as path=35, line=32, match=1;31 (the defaults), or
+sep=36, each giving the SGR parameters for one part of the output.
+
+The -rank flag prints the results for the n most relevant files first,
+followed by the results for the other files in the usual order. A file
+is more relevant if it has more matches, if they are closer together,
+if they are in definitions (lines beginning func, type, class, def, and
+so on), if the regexp matches the file name, and if its path is short.
+Tests and vendored code are less relevant. Programs using the index
+package can rank files their own way with index.Rank and a Scorer.
+
+The -replace flag replaces each match of regexp with template, in which
+$1 or ${1} stands for the text matching the first parenthesized group,
+${name} for the group named by (?P<name>...), and $$ for a dollar sign.
+As in the search, matches are leftmost-longest and, without -U, do not
+span lines. By default csearch only prints the changes it would make,
+as a unified diff suitable for patch or git apply. The -write flag makes
+the changes instead, replacing each file as a whole, so that other
+programs see either the old or the new content, and skipping any file
+that changed after csearch read it. A symbolic link is left in place and
+the file it refers to is replaced. The new file keeps the permissions
+and, where the system allows, the owner and group of the old one, but
+not its extended attributes or hard links. Csearch does not edit files
+in zip archives or files not encoded in UTF-8. The -replace flag cannot be
+combined with -c, -l, -o, -json, -html, -fuzzy, or -rank.
+
+The -encoding flag names the encoding of files whose names match glob
+and that do not begin with a byte order mark, as in cindex. Without the
+flag, csearch uses the rules that cindex recorded in the index.
+Byte offsets and columns count the converted UTF-8 text, from which
+any byte order mark has been removed: in a UTF-8 file that begins with
+a mark, offsets are 3 less than in the file, as are columns on line 1.
+
+The -query flag causes csearch to interpret its argument as a query
+instead of a single regular expression. A query is a space-separated list
+of terms, all of which must match:
+
+	word         regular expression to search for in file contents
+	"text"       literal text to search for in file contents
+	file:word    file name must match regular expression (or "literal")
+	-file:word   file name must not match
+	lang:name    file must be written in the named language (go, c, python, ...)
+	root:path    file must be in the directory tree rooted at path
+	case:yes     matches are case-sensitive
+	case:no      matches are case-insensitive (the default with -i)
+	case:auto    matches are case-sensitive only if the pattern has
+	             upper case letters (the default without -i)
+
+Terms can be grouped with parentheses and combined with "or".
+For example:
+
+	csearch -query 'lang:go -file:_test\.go$ (MarshalJSON or UnmarshalJSON)'
+
+The -max-files, -max-bytes, and -timeout flags bound the work done by a
+search: csearch reads at most n candidate files or n bytes of file content,
+and stops after the duration d (for example, 10s). When a search stops
+early, csearch prints a note saying the results are incomplete, and if
+there are no matches, exits with status 2 rather than 1.
+
+The -stats flag prints statistics about the search to standard error
+when it finishes: the number of trigrams in the index query, the number
+of candidate files the index returned, the files and bytes read, the
+files that could not be opened, the files that matched and the number of
+matches, and the time taken by each phase of the search. With -json,
+the statistics also appear in the final summary object, as "search".
+
+The -serve-stdio flag makes csearch a search server for editors and
+other tools: instead of searching once, it reads JSON-RPC 2.0 requests
+from standard input, one per line, and writes responses and
+notifications to standard output, one per line. It keeps the index open
+between searches and reopens it when cindex replaces it. A "search"
+request runs a search, with parameters
+
+	{"pattern": "regexp", "query": false, "ignore_case": false,
+	 "multiline": false, "file": "fileregexp", "exclude": ["glob"],
+	 "roots": ["dir"], "context": 0, "limit": 0, "max_files": 0,
+	 "timeout": "10s"}
+
+in which only pattern is required and the others are as for the flags
+-query, -i, -U, -f, -exclude, -root, -C, -max-total, -max-files, and
+-timeout. Searches run concurrently. As a search finds results, csearch
+sends "result" notifications, each with params {"id": id, "event": event},
+where id is the request's ID and event is an object as printed by -json.
+When the search finishes, the response gives the number of matches, as
+{"matches": n, "files_matched": n, "incomplete": "reason"}, in which
+incomplete is present only if the search stopped early. A "cancel"
+request, with params {"id": id}, stops the search with that ID, whose
+response is then an error with code -32800. Csearch exits at the end
+of its input, after the searches still running finish.
+
 Csearch relies on the existence of an up-to-date index created ahead of time.
 To build or rebuild the index that csearch uses, run:
 
@@ -51,14 +213,43 @@ func usage() {
 var (
 	fFlag       = flag.String("f", "", "search only files with names matching this regexp")
 	iFlag       = flag.Bool("i", false, "case-insensitive search")
+	uFlag       = flag.Bool("U", false, "allow matches to span lines")
+	fuzzyFlag   = flag.Int("fuzzy", 0, "find text within edit distance `k` of a literal")
+	queryFlag   = flag.Bool("query", false, "interpret argument as a query")
 	htmlFlag    = flag.Bool("html", false, "print HTML output")
 	verboseFlag = flag.Bool("verbose", false, "print extra information")
 	bruteFlag   = flag.Bool("brute", false, "brute force - search all files in index")
 	cpuProfile  = flag.String("cpuprofile", "", "write cpu profile to this file")
+	maxFiles    = flag.Int("max-files", 0, "search at most `n` candidate files")
+	maxBytes    = flag.Int64("max-bytes", 0, "read at most `n` bytes of file content")
+	timeout     = flag.Duration("timeout", 0, "stop searching after duration `d`")
+	rankFlag    = flag.Int("rank", 0, "print the `n` most relevant files first")
+	writeFlag   = flag.Bool("write", false, "with -replace, edit files instead of printing a diff")
+	serveFlag   = flag.Bool("serve-stdio", false, "serve searches as JSON-RPC on standard input and output")
 
-	matches bool
+	matches    bool
+	incomplete bool
+	roots      []index.Path
+	replace    *string // -replace template, if set
 )
 
+func init() {
+	addRoot := func(dir string) error {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, index.MakePath(dir))
+		return nil
+	}
+	flag.Func("root", "search only files in the directory tree rooted at `dir` (repeatable)", addRoot)
+	flag.Func("dir", "synonym for -root", addRoot)
+	flag.Func("replace", "replace matches with `template`, showing a diff", func(s string) error {
+		replace = &s
+		return nil
+	})
+}
+
 func Main() {
 	log.SetPrefix("csearch: ")
 	g := regexp.Grep{
@@ -66,12 +257,49 @@ func Main() {
 		Stderr: os.Stderr,
 	}
 	g.AddFlags()
+	var exclude regexp.Exclude
+	exclude.AddFlags()
+	var encodings charset.Map
+	encodings.AddFlags()
+	g.Encodings = &encodings
 
 	flag.Usage = usage
 	flag.Parse()
+	if *serveFlag {
+		if flag.NArg() != 0 {
+			usage()
+		}
+		s := &stdioServer{
+			file:      index.File(),
+			encodings: &encodings,
+			stderr:    os.Stderr,
+			out:       os.Stdout,
+		}
+		if err := s.serve(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		matches = true // exit 0
+		return
+	}
 	if *htmlFlag {
 		g.HTML = true
 	}
+	if g.JSON && (g.C || g.L || g.HTML) {
+		log.Fatal("-json cannot be combined with -c, -l, or -html")
+	}
+	if replace != nil && (g.C || g.L || g.O || g.JSON || g.HTML || *fuzzyFlag > 0 || *rankFlag > 0) {
+		log.Fatal("-replace cannot be combined with -c, -l, -o, -json, -html, -fuzzy, or -rank")
+	}
+	if *writeFlag && replace == nil {
+		log.Fatal("-write requires -replace")
+	}
+	if err := g.InitColor(); err != nil {
+		log.Fatal(err)
+	}
+	if g.Column || g.Vimgrep {
+		// Editors want names relative to the current directory.
+		g.Dir, _ = os.Getwd()
+	}
 	args := flag.Args()
 
 	if len(args) != 1 {
@@ -88,13 +316,68 @@ func Main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	pat := "(?m)" + args[0]
-	if *iFlag {
-		pat = "(?i)" + pat
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
-	re, err := regexp.Compile(pat)
-	if err != nil {
-		log.Fatal(err)
+	g.MaxBytes = *maxBytes
+
+	var (
+		pat      string
+		re       *regexp.Regexp
+		q        *index.Query
+		search   *query.Search
+		err      error
+		fuzzyTri []string // with -fuzzy, candidate files contain
+		fuzzyMin int      // at least fuzzyMin of fuzzyTri
+	)
+	if *fuzzyFlag > 0 && (*iFlag || g.O || *uFlag || *queryFlag) {
+		log.Fatal("-fuzzy cannot be combined with -i, -o, -U, or -query")
+	}
+	if *fuzzyFlag > 0 {
+		re, err = regexp.CompileFuzzy(args[0], *fuzzyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fuzzyTri, fuzzyMin = index.FuzzyTrigrams(args[0], *fuzzyFlag)
+	} else if *queryFlag {
+		c := query.CaseAuto
+		if *iFlag {
+			c = query.CaseInsensitive
+		}
+		e, err := query.Parse(args[0], c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		compile := query.Compile
+		if *uFlag {
+			compile = query.CompileMultiline
+		}
+		search, err = compile(e)
+		if err != nil {
+			log.Fatal(err)
+		}
+		re = search.Regexp
+		q = search.Query
+		if *verboseFlag {
+			log.Printf("parsed query: %s\n", e)
+		}
+	} else {
+		pat = "(?m)" + args[0]
+		if *iFlag {
+			pat = "(?i)" + pat
+		}
+		if *uFlag {
+			re, err = regexp.CompileMultiline(pat)
+		} else {
+			re, err = regexp.Compile(pat)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		q = index.RegexpQuery(re.Syntax)
 	}
 	g.Regexp = re
 	var fre *regexp.Regexp
@@ -104,96 +387,166 @@ func Main() {
 			log.Fatal(err)
 		}
 	}
-	q := index.RegexpQuery(re.Syntax)
 	if *verboseFlag {
-		log.Printf("query: %s\n", q)
+		if *fuzzyFlag > 0 {
+			log.Printf("query: at least %d of %q\n", fuzzyMin, fuzzyTri)
+		} else {
+			log.Printf("query: %s\n", q)
+		}
+	}
+
+	if g.Stats != nil {
+		g.Stats.EndPhase("parse")
 	}
 
 	ix := index.Open(index.File())
 	ix.Verbose = *verboseFlag
+	if len(encodings.Rules()) == 0 {
+		// Convert files as cindex did.
+		encodings = *ix.Encodings()
+	}
+	if roots == nil && search != nil {
+		roots = search.Roots()
+	}
 	var post []int
-	if *bruteFlag {
-		post = ix.PostingQuery(&index.Query{Op: index.QAll})
-	} else {
-		post = ix.PostingQuery(q)
+	switch {
+	case *bruteFlag:
+		post, err = ix.PostingQueryContext(ctx, &index.Query{Op: index.QAll}, roots...)
+	case *fuzzyFlag > 0:
+		post, err = ix.PostingAtLeast(ctx, fuzzyTri, fuzzyMin, roots...)
+	default:
+		post, err = ix.PostingQueryContext(ctx, q, roots...)
+	}
+	if err != nil {
+		g.Incomplete = err
 	}
 	if *verboseFlag {
 		log.Printf("post query identified %d possible files\n", len(post))
 	}
+	if st := g.Stats; st != nil {
+		st.Indexed = true
+		st.Candidates = len(post)
+		switch {
+		case *bruteFlag:
+		case *fuzzyFlag > 0:
+			st.QuerySize = len(fuzzyTri)
+		default:
+			st.QuerySize = q.Size()
+		}
+		st.EndPhase("index")
+	}
 
-	if fre != nil {
+	if fre != nil || search != nil || !exclude.Empty() {
 		fnames := make([]int, 0, len(post))
 
 		for _, fileid := range post {
-			name := ix.Name(fileid)
-			if fre.MatchString(name.String(), true, true) < 0 {
+			name := ix.Name(fileid).String()
+			if fre != nil && fre.MatchString(name, true, true) < 0 {
+				continue
+			}
+			if search != nil && !search.MatchName(name) {
+				continue
+			}
+			if exclude.Match(name) {
 				continue
 			}
 			fnames = append(fnames, fileid)
 		}
 
 		if *verboseFlag {
-			log.Printf("filename regexp matched %d files\n", len(fnames))
+			log.Printf("filename filters matched %d files\n", len(fnames))
 		}
 		post = fnames
 	}
 
-	var (
-		zipFile   string
-		zipReader *zip.ReadCloser
-		zipMap    map[string]*zip.File
-	)
+	if *maxFiles > 0 && len(post) > *maxFiles {
+		g.Incomplete = fmt.Errorf("searched only %d of %d candidate files", *maxFiles, len(post))
+		post = post[:*maxFiles]
+	}
+	if g.Stats != nil {
+		g.Stats.EndPhase("filter")
+	}
 
-	for _, fileid := range post {
-		name := ix.Name(fileid).String()
-		if g.L && (pat == "(?m)" || pat == "(?i)(?m)") {
-			g.Reader(bytes.NewReader(nil), name)
-			continue
+	if replace != nil {
+		if re == nil {
+			log.Fatal("-replace: query has no regexp to replace")
 		}
-		file, err := os.Open(string(name))
-		if err != nil {
-			if i := strings.Index(name, ".zip\x01"); i >= 0 {
-				zfile, zname := name[:i+4], name[i+5:]
-				if zfile != zipFile {
-					if zipReader != nil {
-						zipReader.Close()
-						zipMap = nil
-					}
-					zipFile = zfile
-					zipReader, err = zip.OpenReader(zfile)
-					if err != nil {
-						zipReader = nil
-					}
-					if zipReader != nil {
-						zipMap = make(map[string]*zip.File)
-						for _, file := range zipReader.File {
-							zipMap[file.Name] = file
-						}
-					}
-				}
-				file := zipMap[zname]
-				if file != nil {
-					r, err := file.Open()
-					if err != nil {
-						continue
-					}
-					g.Reader(r, name)
-					r.Close()
-					continue
-				}
+		rp := &replacer{
+			re:        re,
+			template:  *replace,
+			write:     *writeFlag,
+			encodings: &encodings,
+			stdout:    os.Stdout,
+			stderr:    os.Stderr,
+		}
+		if dir, err := os.Getwd(); err == nil {
+			rp.dir = dir
+		}
+		if search != nil && search.Verify {
+			rp.keep = search.Match
+		}
+		for _, fileid := range post {
+			if err := ctx.Err(); err != nil {
+				g.Incomplete = err
+				break
 			}
-			continue
+			if err := rp.replace(ctx, ix.Name(fileid).String()); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *writeFlag {
+			log.Printf("replaced %d matches in %d files\n", rp.edits, rp.files)
+		}
+		if g.Stats != nil {
+			g.Stats.EndPhase("replace")
 		}
-		g.Reader(file, name)
-		file.Close()
+		g.Finish()
+		matches = rp.edits > 0
+		incomplete = g.Incomplete != nil
+		return
+	}
+
+	var rk *ranker
+	if *rankFlag > 0 {
+		rk = newRanker(&g, re)
+	}
+
+	if g.L && (pat == "(?m)" || pat == "(?i)(?m)") {
+		// No content to match: list the files that match by name.
+		g.Regexp = nil
+	}
+	query.Grep(ctx, &g, search, func(yield func(string) bool) {
+		for _, fileid := range post {
+			name := ix.Name(fileid).String()
+			if rk != nil {
+				rk.start(name)
+			}
+			if !yield(name) {
+				return
+			}
+		}
+	})
+	if rk != nil {
+		rk.print(*rankFlag)
+	}
+	if g.Stats != nil {
+		g.Stats.EndPhase("search")
+	}
+	g.Finish()
+	if *verboseFlag && re != nil && *fuzzyFlag == 0 {
+		log.Printf("regexp cache: %+v\n", re.CacheStats())
 	}
 
 	matches = g.Match
+	incomplete = g.Incomplete != nil
 }
 
 func main() {
 	Main()
 	if !matches {
+		if incomplete {
+			os.Exit(2)
+		}
 		os.Exit(1)
 	}
 	os.Exit(0)
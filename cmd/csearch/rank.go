@@ -0,0 +1,144 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"path/filepath"
+	stdregexp "regexp"
+	"strings"
+
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/regexp"
+)
+
+// A ranker holds the output for each file searched
+// so that the files can be printed in order of relevance.
+type ranker struct {
+	g      *regexp.Grep
+	re     *regexp.Regexp
+	stdout io.Writer
+	files  []*rankedFile
+	cur    *rankedFile
+	bytes  int64 // g.Bytes when cur started
+	count  int   // g.Matches when cur started
+}
+
+type rankedFile struct {
+	index.FileMatch
+	out bytes.Buffer
+}
+
+// newRanker returns a ranker that captures g's output.
+// The regexp re, if not nil, is matched against file names.
+func newRanker(g *regexp.Grep, re *regexp.Regexp) *ranker {
+	rk := &ranker{g: g, re: re, stdout: g.Stdout}
+	g.OnMatch = func(name string, lineno int, line []byte) {
+		if isDefinition(line) {
+			rk.cur.Defs++
+		}
+	}
+	return rk
+}
+
+// start finishes the current file and starts capturing output for the named file.
+func (rk *ranker) start(name string) {
+	rk.finish()
+	rk.cur = &rankedFile{FileMatch: index.FileMatch{Name: name}}
+	rk.bytes, rk.count = rk.g.Bytes, rk.g.Matches
+	rk.g.Stdout = &rk.cur.out
+}
+
+// finish records the current file's matches.
+func (rk *ranker) finish() {
+	f := rk.cur
+	if f == nil {
+		return
+	}
+	rk.cur = nil
+	if f.out.Len() == 0 {
+		return
+	}
+	f.Size = rk.g.Bytes - rk.bytes
+	f.Matches = rk.g.Matches - rk.count
+	f.NameMatch = rk.re != nil && rk.re.MatchString(filepath.Base(f.Name), true, true) >= 0
+	rk.files = append(rk.files, f)
+}
+
+// print prints the output for the n highest-ranked files,
+// best first, followed by the output for the rest in index order,
+// and restores g's output.
+func (rk *ranker) print(n int) {
+	rk.finish()
+	rk.g.Stdout = rk.stdout
+	ranked := make([]*index.FileMatch, len(rk.files))
+	byMatch := make(map[*index.FileMatch]*rankedFile)
+	for i, f := range rk.files {
+		ranked[i] = &f.FileMatch
+		byMatch[&f.FileMatch] = f
+	}
+	index.Rank(ranked, defaultScorer)
+	top := make(map[*rankedFile]bool)
+	for _, m := range ranked[:min(n, len(ranked))] {
+		f := byMatch[m]
+		top[f] = true
+		rk.stdout.Write(f.out.Bytes())
+	}
+	for _, f := range rk.files {
+		if !top[f] {
+			rk.stdout.Write(f.out.Bytes())
+		}
+	}
+}
+
+// defaultScorer is the Scorer used by -rank.
+// It favors files with many matches, with matches close together,
+// with matches in definitions or in the file name itself,
+// and with short paths, and it penalizes tests and vendored code.
+var defaultScorer index.Scorer = index.ScorerFunc(defaultScore)
+
+func defaultScore(f *index.FileMatch) float64 {
+	s := math.Log2(1 + float64(f.Matches))
+	if f.Size > 0 {
+		// Up to one point for a match every 100 bytes.
+		s += min(1, float64(f.Matches)*100/float64(f.Size))
+	}
+	s += 2 * math.Log2(1+float64(f.Defs))
+	if f.NameMatch {
+		s += 3
+	}
+	s -= 0.1 * float64(strings.Count(filepath.ToSlash(f.Name), "/"))
+	if isTest(f.Name) {
+		s -= 1.5
+	}
+	if isVendor(f.Name) {
+		s -= 3
+	}
+	return s
+}
+
+var (
+	testRE   = stdregexp.MustCompile(`(_test\.[^/]+|\.(test|spec)\.[^/]+|(^|/)(test|tests|testdata|__tests__)/)`)
+	vendorRE = stdregexp.MustCompile(`(^|/)(vendor|third_party|node_modules)/`)
+	defRE    = stdregexp.MustCompile(`^\s*(export\s+)?((public|private|protected|static|async|abstract|final|pub(\([a-z]+\))?|extern|inline)\s+)*(func|type|class|struct|interface|enum|trait|impl|def|fn|var|const|let|module|namespace|typedef|#define)\b`)
+)
+
+// isTest reports whether the named file looks like a test.
+func isTest(name string) bool {
+	return testRE.MatchString(filepath.ToSlash(name))
+}
+
+// isVendor reports whether the named file looks like vendored code.
+func isVendor(name string) bool {
+	return vendorRE.MatchString(filepath.ToSlash(name))
+}
+
+// isDefinition reports whether line looks like it defines something,
+// such as a function, type, or constant, in any common language.
+func isDefinition(line []byte) bool {
+	return defRE.Match(line)
+}
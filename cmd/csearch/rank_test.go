@@ -0,0 +1,91 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/codesearch/index"
+)
+
+func TestDefaultScorer(t *testing.T) {
+	files := []*index.FileMatch{
+		{Name: "/src/a/b/c/deep.go", Size: 1000, Matches: 3},
+		{Name: "/src/a/few.go", Size: 1000, Matches: 1},
+		{Name: "/src/a/many.go", Size: 1000, Matches: 20},
+		{Name: "/src/a/many_test.go", Size: 1000, Matches: 20},
+		{Name: "/src/vendor/x/many.go", Size: 1000, Matches: 20},
+		{Name: "/src/a/def.go", Size: 1000, Matches: 3, Defs: 1},
+		{Name: "/src/a/name.go", Size: 1000, Matches: 3, NameMatch: true},
+		{Name: "/src/a/dense.go", Size: 30, Matches: 3},
+		{Name: "/src/a/shallow.go", Size: 1000, Matches: 3},
+	}
+	index.Rank(files, defaultScorer)
+	want := []string{
+		"/src/a/many.go",
+		"/src/a/name.go",
+		"/src/a/def.go",
+		"/src/a/many_test.go",
+		"/src/a/dense.go",
+		"/src/a/shallow.go",
+		"/src/vendor/x/many.go",
+		"/src/a/b/c/deep.go",
+		"/src/a/few.go",
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Name)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Rank(defaultScorer):\nhave %q\nwant %q", got, want)
+	}
+	for i := 1; i < len(files); i++ {
+		if files[i-1].Score < files[i].Score {
+			t.Errorf("scores out of order: %s %g before %s %g", files[i-1].Name, files[i-1].Score, files[i].Name, files[i].Score)
+		}
+	}
+}
+
+func TestIsDefinition(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		def  bool
+	}{
+		{"func Open(file string) *Index {", true},
+		{"\ttype Query struct {", true},
+		{"class Reader(object):", true},
+		{"    def read(self):", true},
+		{"pub(crate) fn parse() {}", true},
+		{"export const x = 1;", true},
+		{"#define MAX 10", true},
+		{"\treturn Open(file)", false},
+		{"// func in a comment", false},
+		{"functional := true", false},
+	} {
+		if def := isDefinition([]byte(tt.line)); def != tt.def {
+			t.Errorf("isDefinition(%q) = %v, want %v", tt.line, def, tt.def)
+		}
+	}
+}
+
+func TestIsTestVendor(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		test, vendor bool
+	}{
+		{"/src/a/x.go", false, false},
+		{"/src/a/x_test.go", true, false},
+		{"/src/a/testdata/x.go", true, false},
+		{"/src/web/x.spec.ts", true, false},
+		{"/src/vendor/x/y.go", false, true},
+		{"/src/node_modules/x/y_test.js", true, true},
+		{"/src/contest/x.go", false, false},
+	} {
+		if isTest(tt.name) != tt.test || isVendor(tt.name) != tt.vendor {
+			t.Errorf("isTest, isVendor(%q) = %v, %v, want %v, %v", tt.name, isTest(tt.name), isVendor(tt.name), tt.test, tt.vendor)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/regexp"
+)
+
+// A replacer rewrites the matches of a regexp in files,
+// either printing the changes as a unified diff or writing them.
+type replacer struct {
+	re        *regexp.Regexp
+	template  string
+	write     bool
+	encodings *charset.Map
+	stdout    io.Writer
+	stderr    io.Writer
+
+	// dir, if not empty, is the directory that names in diffs are
+	// relative to, so that the diffs can be applied with patch -p0.
+	dir string
+
+	// keep, if not nil, reports whether to edit a file with the given content,
+	// without any byte order mark.
+	keep func(name string, data []byte) bool
+
+	files int // files changed
+	edits int // matches replaced
+}
+
+// replace rewrites the matches in the named file.
+func (rp *replacer) replace(ctx context.Context, name string) error {
+	if strings.Contains(name, ".zip\x01") {
+		fmt.Fprintf(rp.stderr, "%s: cannot replace in zip archive\n", name)
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		fmt.Fprintf(rp.stderr, "%s\n", err)
+		return nil
+	}
+	e, bom, ok := charset.Sniff(data)
+	if ok && e != charset.UTF8 || !ok && rp.encodings.Lookup(name) != charset.UTF8 {
+		fmt.Fprintf(rp.stderr, "%s: cannot replace in file not encoded in UTF-8\n", name)
+		return nil
+	}
+	if rp.keep != nil && !rp.keep(name, data[bom:]) {
+		return nil
+	}
+	edits, err := rp.re.Replacements(data, rp.template)
+	if err != nil {
+		return err
+	}
+	// Drop edits that change nothing.
+	n := 0
+	for _, e := range edits {
+		if !bytes.Equal(data[e.Start:e.End], e.New) {
+			edits[n] = e
+			n++
+		}
+	}
+	edits = edits[:n]
+	if len(edits) == 0 {
+		return nil
+	}
+	rp.files++
+	rp.edits += len(edits)
+	if !rp.write {
+		_, err := rp.stdout.Write(unifiedDiff(rp.diffName(name), data, edits))
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := writeFile(name, data, regexp.Apply(data, edits)); err != nil {
+		fmt.Fprintf(rp.stderr, "%s\n", err)
+	}
+	return nil
+}
+
+// diffName returns the name to print for the named file in a diff.
+func (rp *replacer) diffName(name string) string {
+	if rp.dir == "" {
+		return name
+	}
+	rel, err := filepath.Rel(rp.dir, name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return name
+	}
+	return filepath.ToSlash(rel)
+}
+
+var errChanged = errors.New("file changed since it was read; not replacing")
+
+// writeFile replaces the content of the named file, old, with new.
+// It writes a temporary file and renames it over the original,
+// so that other programs see either the old or the new content.
+// If name is a symbolic link, writeFile replaces the file it refers to,
+// leaving the link in place. The new file gets the permissions of the
+// original and, where the system allows, its owner and group.
+// It refuses to write if the file no longer contains old.
+func writeFile(name string, old, new []byte) error {
+	name, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	cur, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cur, old) {
+		return &os.PathError{Op: "replace", Path: name, Err: errChanged}
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".csearch*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(new)
+	if err == nil {
+		chownLike(f, fi)
+		err = f.Chmod(fi.Mode().Perm())
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// A region is a run of whole lines, old[start:end] of the lines of the
+// original text, that the edits replace with the text of lines new.
+type region struct {
+	start, end int
+	new        [][]byte
+}
+
+// unifiedDiff returns the unified diff between data and data with
+// the edits applied, which must be in order and not overlap.
+func unifiedDiff(name string, data []byte, edits []regexp.Edit) []byte {
+	lines := splitLines(data)
+	offs := make([]int, len(lines)+1) // offs[i] is the offset of line i
+	for i, l := range lines {
+		offs[i+1] = offs[i] + len(l)
+	}
+	// lineAt returns the index of the line containing data[off].
+	lineAt := func(off int) int {
+		i := sort.Search(len(lines), func(i int) bool { return offs[i+1] > off })
+		return min(i, max(len(lines)-1, 0)) // insertion at end of text
+	}
+
+	// Group the edits into regions of whole lines,
+	// merging edits on the same or adjacent lines.
+	var regions []region
+	for i := 0; i < len(edits); {
+		start := lineAt(edits[i].Start)
+		end := lineAt(max(edits[i].End-1, edits[i].Start)) + 1
+		j := i + 1
+		for j < len(edits) && lineAt(edits[j].Start) <= end {
+			end = max(end, lineAt(max(edits[j].End-1, edits[j].Start))+1)
+			j++
+		}
+		end = min(end, len(lines))
+		lo, hi := offs[start], offs[end]
+		var text []byte
+		pos := lo
+		for _, e := range edits[i:j] {
+			text = append(text, data[pos:e.Start]...)
+			text = append(text, e.New...)
+			pos = e.End
+		}
+		text = append(text, data[pos:hi]...)
+		regions = append(regions, region{start, end, splitLines(text)})
+		i = j
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", name, name)
+	delta := 0 // lines added minus lines removed in earlier hunks
+	for i := 0; i < len(regions); {
+		// A hunk contains the regions whose context lines overlap.
+		j := i + 1
+		for j < len(regions) && regions[j].start-regions[j-1].end <= 2*diffContext {
+			j++
+		}
+		start := max(regions[i].start-diffContext, 0)
+		end := min(regions[j-1].end+diffContext, len(lines))
+		var hunk bytes.Buffer
+		oldN, newN := end-start, end-start
+		pos := start
+		for _, r := range regions[i:j] {
+			writeLines(&hunk, ' ', lines[pos:r.start])
+			writeLines(&hunk, '-', lines[r.start:r.end])
+			writeLines(&hunk, '+', r.new)
+			newN += len(r.new) - (r.end - r.start)
+			pos = r.end
+		}
+		writeLines(&hunk, ' ', lines[pos:end])
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(start, oldN), hunkRange(start+delta, newN))
+		b.Write(hunk.Bytes())
+		delta += newN - oldN
+		i = j
+	}
+	return b.Bytes()
+}
+
+// hunkRange formats the start and length of a hunk's lines
+// as in a unified diff header.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// splitLines splits b into lines, each ending in \n
+// except perhaps the last.
+func splitLines(b []byte) [][]byte {
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func writeLines(b *bytes.Buffer, prefix byte, lines [][]byte) {
+	for _, l := range lines {
+		b.WriteByte(prefix)
+		b.Write(l)
+		if len(l) == 0 || l[len(l)-1] != '\n' {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.go")
+	link := filepath.Join(dir, "sub", "link.go")
+	if err := os.WriteFile(target, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Dir(link), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../target.go", link); err != nil {
+		t.Skipf("cannot create symbolic link: %v", err)
+	}
+
+	if err := writeFile(link, []byte("old\n"), []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("after writeFile, %s is not a symbolic link: %v, %v", link, fi.Mode(), err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "new\n" {
+		t.Errorf("after writeFile, target contains %q, %v, want %q", data, err, "new\n")
+	}
+	if fi, err := os.Stat(target); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("after writeFile, target mode %v, %v, want %v", fi.Mode(), err, os.FileMode(0640))
+	}
+	for _, d := range []string{dir, filepath.Dir(link)} {
+		if tmp, _ := filepath.Glob(filepath.Join(d, ".*.csearch*")); len(tmp) > 0 {
+			t.Errorf("temporary files left behind: %q", tmp)
+		}
+	}
+
+	// A file that changed since it was read is left alone.
+	if err := writeFile(link, []byte("old\n"), []byte("newer\n")); !errors.Is(err, errChanged) {
+		t.Errorf("writeFile of changed file: %v, want %v", err, errChanged)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/query"
+	"github.com/google/codesearch/regexp"
+)
+
+// A stdioServer serves searches as JSON-RPC 2.0 requests and responses,
+// one per line, for csearch -serve-stdio.
+// It keeps the index open between searches, reopening it when
+// cindex replaces it, and runs each search in its own goroutine
+// so that a later request can cancel it.
+type stdioServer struct {
+	file      string // index file
+	encodings *charset.Map
+	stderr    io.Writer // for errors reading files
+
+	outMu sync.Mutex
+	out   io.Writer
+
+	mu      sync.Mutex
+	ix      *sharedIndex                  // current index
+	running map[string]context.CancelFunc // in-flight searches, by request ID
+	wg      sync.WaitGroup
+}
+
+// A sharedIndex is an index in use by searches.
+// An index replaced by a newer one is closed
+// when the last search using it finishes.
+type sharedIndex struct {
+	*index.Index
+	refs int
+}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeCanceled       = -32800 // as in LSP
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// searchParams are the parameters of a search request.
+type searchParams struct {
+	Pattern    string   `json:"pattern"`     // regexp, or query if Query is set
+	Query      bool     `json:"query"`       // Pattern is a query, as with -query
+	IgnoreCase bool     `json:"ignore_case"` // as with -i
+	Multiline  bool     `json:"multiline"`   // as with -U
+	File       string   `json:"file"`        // as with -f
+	Exclude    []string `json:"exclude"`     // globs, as with -exclude
+	Roots      []string `json:"roots"`       // as with -root
+	Context    int      `json:"context"`     // as with -C
+	Limit      int      `json:"limit"`       // as with -max-total
+	MaxFiles   int      `json:"max_files"`   // as with -max-files
+	Timeout    string   `json:"timeout"`     // as with -timeout
+}
+
+// searchResult is the result of a search request.
+type searchResult struct {
+	Matches      int    `json:"matches"`
+	FilesMatched int    `json:"files_matched"`
+	Incomplete   string `json:"incomplete,omitempty"`
+}
+
+// cancelParams are the parameters of a cancel request.
+type cancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+// A resultEvent is the parameters of a result notification:
+// a JSON event written by Grep, as with -json,
+// for the search request with the given ID.
+type resultEvent struct {
+	ID    json.RawMessage `json:"id"`
+	Event json.RawMessage `json:"event"`
+}
+
+// serve reads requests from in until it reaches the end of the input,
+// then waits for the searches still running to finish.
+func (s *stdioServer) serve(in io.Reader) error {
+	s.running = make(map[string]context.CancelFunc)
+	r := bufio.NewReader(in)
+	var err error
+	for {
+		var line []byte
+		line, err = r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.handle(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.wg.Wait()
+	s.mu.Lock()
+	if s.ix != nil && s.ix.refs == 0 {
+		s.ix.Close()
+		s.ix = nil
+	}
+	s.mu.Unlock()
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// handle handles a single request.
+func (s *stdioServer) handle(line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.reply(json.RawMessage("null"), nil, &rpcError{codeParseError, err.Error()})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		s.reply(req.ID, nil, &rpcError{codeInvalidRequest, "not a JSON-RPC 2.0 request"})
+		return
+	}
+	switch req.Method {
+	default:
+		s.reply(req.ID, nil, &rpcError{codeMethodNotFound, "unknown method " + req.Method})
+
+	case "search":
+		if req.ID == nil {
+			// There is no way to tell the client about the results.
+			return
+		}
+		var p searchParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			s.reply(req.ID, nil, &rpcError{codeInvalidParams, err.Error()})
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if p.Timeout != "" {
+			d, err := time.ParseDuration(p.Timeout)
+			if err != nil {
+				cancel()
+				s.reply(req.ID, nil, &rpcError{codeInvalidParams, err.Error()})
+				return
+			}
+			ctx, cancel = context.WithTimeout(ctx, d)
+		}
+		key := string(req.ID)
+		s.mu.Lock()
+		if _, ok := s.running[key]; ok {
+			s.mu.Unlock()
+			cancel()
+			s.reply(req.ID, nil, &rpcError{codeInvalidRequest, "duplicate request ID " + key})
+			return
+		}
+		s.running[key] = cancel
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			res, err := s.search(ctx, req.ID, &p)
+			canceled := errors.Is(ctx.Err(), context.Canceled)
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+			cancel()
+			switch {
+			case err != nil:
+				s.reply(req.ID, nil, &rpcError{codeInvalidParams, err.Error()})
+			case canceled:
+				s.reply(req.ID, nil, &rpcError{codeCanceled, "request canceled"})
+			default:
+				s.reply(req.ID, res, nil)
+			}
+		}()
+
+	case "cancel":
+		var p cancelParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == nil {
+			s.reply(req.ID, nil, &rpcError{codeInvalidParams, "cancel requires the id of a request"})
+			return
+		}
+		s.mu.Lock()
+		cancel := s.running[string(p.ID)]
+		s.mu.Unlock()
+		if cancel != nil {
+			// The canceled search replies when it stops.
+			cancel()
+		}
+		s.reply(req.ID, struct{}{}, nil)
+	}
+}
+
+// reply sends the response to the request with the given ID.
+// There is no response to a notification, which has no ID.
+func (s *stdioServer) reply(id json.RawMessage, result any, err *rpcError) {
+	if id == nil {
+		return
+	}
+	s.write(&rpcResponse{JSONRPC: "2.0", ID: id, Result: result, Error: err})
+}
+
+// write writes the JSON form of v as a line of output.
+func (s *stdioServer) write(v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// Only the types above are marshaled.
+		panic(err)
+	}
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.out.Write(append(b, '\n'))
+}
+
+// An eventWriter sends the JSON events written by Grep,
+// one per line, as result notifications for a request.
+type eventWriter struct {
+	s   *stdioServer
+	id  json.RawMessage
+	buf []byte
+}
+
+func (w *eventWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.s.write(&rpcNotification{JSONRPC: "2.0", Method: "result", Params: &resultEvent{w.id, w.buf[:i]}})
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// acquire returns the current index, opening it, or reopening it
+// if cindex has replaced it. The caller must release it when done.
+func (s *stdioServer) acquire() *sharedIndex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ix != nil && s.ix.Changed() {
+		old := s.ix
+		s.ix = nil
+		if old.refs == 0 {
+			old.Close()
+		}
+	}
+	if s.ix == nil {
+		s.ix = &sharedIndex{Index: index.Open(s.file)}
+	}
+	s.ix.refs++
+	return s.ix
+}
+
+// release releases an index returned by acquire,
+// closing it if it has been replaced and is no longer in use.
+func (s *stdioServer) release(ix *sharedIndex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ix.refs--
+	if ix.refs == 0 && ix != s.ix {
+		ix.Close()
+	}
+}
+
+// search runs the search with the given parameters,
+// sending the results as notifications for request id.
+func (s *stdioServer) search(ctx context.Context, id json.RawMessage, p *searchParams) (*searchResult, error) {
+	if p.Pattern == "" {
+		return nil, fmt.Errorf("missing pattern")
+	}
+	if p.Context < 0 || p.Limit < 0 || p.MaxFiles < 0 {
+		return nil, fmt.Errorf("context, limit, and max_files must not be negative")
+	}
+	var (
+		re     *regexp.Regexp
+		q      *index.Query
+		search *query.Search
+		err    error
+	)
+	if p.Query {
+		c := query.CaseAuto
+		if p.IgnoreCase {
+			c = query.CaseInsensitive
+		}
+		e, err := query.Parse(p.Pattern, c)
+		if err != nil {
+			return nil, err
+		}
+		compile := query.Compile
+		if p.Multiline {
+			compile = query.CompileMultiline
+		}
+		search, err = compile(e)
+		if err != nil {
+			return nil, err
+		}
+		re = search.Regexp
+		q = search.Query
+	} else {
+		pat := "(?m)" + p.Pattern
+		if p.IgnoreCase {
+			pat = "(?i)" + pat
+		}
+		if p.Multiline {
+			re, err = regexp.CompileMultiline(pat)
+		} else {
+			re, err = regexp.Compile(pat)
+		}
+		if err != nil {
+			return nil, err
+		}
+		q = index.RegexpQuery(re.Syntax)
+	}
+	var fre *regexp.Regexp
+	if p.File != "" {
+		fre, err = regexp.Compile(p.File)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var exclude regexp.Exclude
+	for _, glob := range p.Exclude {
+		if err := exclude.AddGlob(glob); err != nil {
+			return nil, err
+		}
+	}
+	var roots []index.Path
+	for _, dir := range p.Roots {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, index.MakePath(dir))
+	}
+	if roots == nil && search != nil {
+		roots = search.Roots()
+	}
+
+	w := &eventWriter{s: s, id: id}
+	g := &regexp.Grep{
+		Regexp:      re,
+		Stdout:      w,
+		Stderr:      s.stderr,
+		JSON:        true,
+		PreContext:  p.Context,
+		PostContext: p.Context,
+		Limit:       p.Limit,
+		Encodings:   s.encodings,
+	}
+
+	ix := s.acquire()
+	defer s.release(ix)
+	if len(g.Encodings.Rules()) == 0 {
+		// Convert files as cindex did.
+		g.Encodings = ix.Encodings()
+	}
+	post, err := ix.PostingQueryContext(ctx, q, roots...)
+	if err != nil {
+		g.Incomplete = err
+	}
+	query.Grep(ctx, g, search, func(yield func(string) bool) {
+		files := 0
+		for _, fileid := range post {
+			name := ix.Name(fileid).String()
+			if fre != nil && fre.MatchString(name, true, true) < 0 ||
+				search != nil && !search.MatchName(name) || exclude.Match(name) {
+				continue
+			}
+			if p.MaxFiles > 0 && files >= p.MaxFiles {
+				g.Incomplete = fmt.Errorf("searched only %d candidate files", p.MaxFiles)
+				return
+			}
+			files++
+			if !yield(name) {
+				return
+			}
+		}
+	})
+	return &searchResult{Matches: g.Matches, FilesMatched: g.FilesMatched, Incomplete: g.Stopped()}, nil
+}
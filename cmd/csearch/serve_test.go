@@ -0,0 +1,192 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/index"
+)
+
+// A testClient talks to a stdioServer running in a goroutine.
+type testClient struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *bufio.Scanner
+	done chan error
+}
+
+// A testMessage is a response or notification from the server.
+type testMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params struct {
+		ID    json.RawMessage `json:"id"`
+		Event struct {
+			Type string `json:"type"`
+			Data struct {
+				Path struct {
+					Text string `json:"text"`
+				} `json:"path"`
+				Lines struct {
+					Text string `json:"text"`
+				} `json:"lines"`
+				LineNumber int `json:"line_number"`
+			} `json:"data"`
+		} `json:"event"`
+	} `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func startServer(t *testing.T, file string) *testClient {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	s := &stdioServer{file: file, encodings: new(charset.Map), stderr: io.Discard, out: outw}
+	c := &testClient{t: t, in: inw, out: bufio.NewScanner(outr), done: make(chan error, 1)}
+	go func() {
+		err := s.serve(inr)
+		outw.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *testClient) send(req string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.in, req+"\n"); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// wait reads messages until the response to the request with the given ID,
+// returning the response and the text of the lines
+// in the result notifications for that request, as "name:lineno:text".
+func (c *testClient) wait(id string) (*testMessage, []string) {
+	c.t.Helper()
+	var lines []string
+	for c.out.Scan() {
+		var m testMessage
+		if err := json.Unmarshal(c.out.Bytes(), &m); err != nil {
+			c.t.Fatalf("bad message %s: %v", c.out.Bytes(), err)
+		}
+		if m.Method == "result" && string(m.Params.ID) == id {
+			ev := &m.Params.Event
+			if ev.Type == "match" {
+				lines = append(lines, fmt.Sprintf("%s:%d:%s", filepath.Base(ev.Data.Path.Text), ev.Data.LineNumber, strings.TrimSuffix(ev.Data.Lines.Text, "\n")))
+			}
+			continue
+		}
+		if m.Method == "" && string(m.ID) == id {
+			return &m, lines
+		}
+	}
+	c.t.Fatalf("no response to request %s: %v", id, c.out.Err())
+	return nil, nil
+}
+
+func (c *testClient) close() {
+	c.t.Helper()
+	c.in.Close()
+	for c.out.Scan() {
+	}
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// writeIndex writes files to dir and indexes them in file,
+// replacing it as cindex does.
+func writeIndex(t *testing.T, file, dir string, files map[string]string) {
+	w := index.Create(file + "~")
+	for _, name := range []string{"a.go", "b.go"} {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	if err := os.Rename(file+"~", file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index")
+	writeIndex(t, file, dir, map[string]string{"a.go": "package a\n\nfunc F() {}\n"})
+	c := startServer(t, file)
+	defer c.close()
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"search","params":{"pattern":"func"}}`)
+	resp, lines := c.wait("1")
+	if resp.Error != nil || string(resp.Result) != `{"matches":1,"files_matched":1}` {
+		t.Errorf("search func: result %s, error %v", resp.Result, resp.Error)
+	}
+	if want := []string{"a.go:3:func F() {}"}; !slices.Equal(lines, want) {
+		t.Errorf("search func: lines %q, want %q", lines, want)
+	}
+
+	// Replacing the index makes the server reopen it.
+	writeIndex(t, file, dir, map[string]string{"b.go": "package b\n\nfunc G() {}\n"})
+	c.send(`{"jsonrpc":"2.0","id":"two","method":"search","params":{"pattern":"func"}}`)
+	resp, lines = c.wait(`"two"`)
+	if resp.Error != nil || string(resp.Result) != `{"matches":1,"files_matched":1}` {
+		t.Errorf("search func after reindex: result %s, error %v", resp.Result, resp.Error)
+	}
+	if want := []string{"b.go:3:func G() {}"}; !slices.Equal(lines, want) {
+		t.Errorf("search func after reindex: lines %q, want %q", lines, want)
+	}
+
+	c.send(`{"jsonrpc":"2.0","id":5,"method":"search","params":{"pattern":"c","limit":1}}`)
+	resp, _ = c.wait("5")
+	if want := `{"matches":1,"files_matched":1,"incomplete":"stopped after 1 match"}`; resp.Error != nil || string(resp.Result) != want {
+		t.Errorf("search with limit: result %s, error %v, want %s", resp.Result, resp.Error, want)
+	}
+
+	for _, tt := range []struct {
+		req  string
+		code int
+	}{
+		{`not json`, codeParseError},
+		{`{"id":3,"method":"search"}`, codeInvalidRequest},
+		{`{"jsonrpc":"2.0","id":3,"method":"frob"}`, codeMethodNotFound},
+		{`{"jsonrpc":"2.0","id":3,"method":"search","params":{"pattern":"("}}`, codeInvalidParams},
+		{`{"jsonrpc":"2.0","id":3,"method":"search","params":{"pattern":"x","timeout":"soon"}}`, codeInvalidParams},
+		{`{"jsonrpc":"2.0","id":3,"method":"cancel","params":{}}`, codeInvalidParams},
+	} {
+		c.send(tt.req)
+		id := "3"
+		if tt.code == codeParseError {
+			id = "null"
+		}
+		resp, _ := c.wait(id)
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: error %v, want code %d", tt.req, resp.Error, tt.code)
+		}
+	}
+
+	// Canceling a search that is not running does nothing.
+	c.send(`{"jsonrpc":"2.0","id":4,"method":"cancel","params":{"id":99}}`)
+	if resp, _ := c.wait("4"); resp.Error != nil || string(resp.Result) != "{}" {
+		t.Errorf("cancel: result %s, error %v", resp.Result, resp.Error)
+	}
+}
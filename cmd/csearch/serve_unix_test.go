@@ -0,0 +1,62 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestServeStdioCancel(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index")
+	writeIndex(t, file, dir, map[string]string{"a.go": "package a\n\nfunc F() {}\n"})
+
+	// Replace a.go with a named pipe, so that the search
+	// blocks reading it until the test writes to the pipe.
+	fifo := filepath.Join(dir, "a.go")
+	if err := os.Remove(fifo); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(fifo, 0666); err != nil {
+		t.Skip(err)
+	}
+
+	c := startServer(t, file)
+	defer c.close()
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"search","params":{"pattern":"func"}}`)
+	c.send(`{"jsonrpc":"2.0","id":2,"method":"cancel","params":{"id":1}}`)
+	if resp, _ := c.wait("2"); resp.Error != nil {
+		t.Fatalf("cancel: error %v", resp.Error)
+	}
+
+	// If the search opens the pipe before it sees that it was canceled,
+	// writing to the pipe lets it finish. If not, the open below
+	// unblocks the writer.
+	wrote := make(chan bool)
+	go func() {
+		if w, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+			w.WriteString("package a\n\nfunc F() {}\n")
+			w.Close()
+		}
+		close(wrote)
+	}()
+	resp, lines := c.wait("1")
+	if r, err := os.OpenFile(fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+		<-wrote
+		r.Close()
+	}
+	<-wrote
+	if resp.Error == nil || resp.Error.Code != codeCanceled {
+		t.Errorf("canceled search: result %s, error %v, want code %d", resp.Result, resp.Error, codeCanceled)
+	}
+	if len(lines) != 0 {
+		t.Errorf("canceled search: lines %q, want none", lines)
+	}
+}
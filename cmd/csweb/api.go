@@ -0,0 +1,225 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/codesearch/query"
+	"github.com/google/codesearch/regexp"
+)
+
+// Limits on the parameters of an API search.
+const (
+	defaultAPILimit = 100
+	maxAPIContext   = 20
+)
+
+// An apiResponse is the response to an API search.
+type apiResponse struct {
+	Query      string      `json:"query"`
+	Offset     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+	Results    []apiResult `json:"results"`
+	More       bool        `json:"more"`                 // there are results after these
+	Incomplete string      `json:"incomplete,omitempty"` // why the search stopped early
+}
+
+// An apiResult is a matching line or, if Line is 0,
+// a file that matches by name alone.
+type apiResult struct {
+	Path       string    `json:"path"`
+	Line       int       `json:"line"`   // line number, from 1
+	Offset     int64     `json:"offset"` // byte offset of the line in the file
+	Text       string    `json:"text"`   // the line, without its newline
+	Submatches []apiSpan `json:"submatches,omitempty"`
+	Before     []apiLine `json:"before,omitempty"` // lines of context before
+	After      []apiLine `json:"after,omitempty"`  // lines of context after
+	lines      int       // lines in Text
+}
+
+// An apiSpan is a match, as byte offsets in the text of a line.
+type apiSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// An apiLine is a line of context.
+type apiLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// An apiError is the response to a bad API request.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
+
+// serveAPI serves a search as JSON. The parameters are
+//
+//	q        the query, as in the search box (required)
+//	file     a regular expression that file names must match, case-sensitively
+//	exclude  a glob for file names to skip, as in csearch -exclude (repeatable)
+//	context  lines of context to return around each matching line
+//	limit    results to return (default 100, at most -max-results)
+//	offset   results to skip, for paging (at most -max-results)
+//
+// Each page is found by searching again from the start,
+// so offset is limited to bound the work of a request.
+func (s *server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.apiSearch(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) apiSearch(r *http.Request) (*apiResponse, error) {
+	q := r.FormValue("q")
+	if q == "" {
+		return nil, fmt.Errorf("missing query")
+	}
+	e, err := query.Parse(q, query.CaseAuto)
+	if err != nil {
+		return nil, err
+	}
+	if f := r.FormValue("file"); f != "" {
+		e = &query.Expr{Op: query.OpAnd, Sub: []*query.Expr{e, {Op: query.OpFile, Arg: f, Case: query.CaseSensitive}}}
+	}
+	search, err := query.Compile(e)
+	if err != nil {
+		return nil, err
+	}
+	var exclude regexp.Exclude
+	for _, glob := range r.Form["exclude"] {
+		if err := exclude.AddGlob(glob); err != nil {
+			return nil, err
+		}
+	}
+	ctxLines, err := intParam(r, "context", 0, 0, maxAPIContext)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParam(r, "limit", min(defaultAPILimit, s.maxResults), 1, s.maxResults)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := intParam(r, "offset", 0, 0, s.maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	c := &collector{ctxLines: ctxLines}
+	g := &regexp.Grep{
+		Regexp:      search.Regexp,
+		Stdout:      io.Discard,
+		Stderr:      &logWriter{},
+		OnLines:     c.add,
+		PreContext:  ctxLines,
+		PostContext: ctxLines,
+		Limit:       offset + limit + 1, // one more, to see whether there are more
+		Encodings:   s.encodings,
+	}
+	s.search(ctx, g, search, &exclude)
+
+	resp := &apiResponse{Query: q, Offset: offset, Limit: limit, Results: []apiResult{}}
+	results := c.results
+	if len(results) > offset+limit || g.Limited {
+		resp.More = true
+	}
+	if offset < len(results) {
+		resp.Results = append(resp.Results, results[offset:min(offset+limit, len(results))]...)
+	}
+	if g.Incomplete != nil {
+		resp.Incomplete = g.Incomplete.Error()
+	}
+	return resp, nil
+}
+
+// intParam returns the integer value of the named parameter,
+// or def if it is not set, checking that it is in [lo, hi].
+func intParam(r *http.Request, name string, def, lo, hi int) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("invalid %s %q: must be an integer from %d to %d", name, v, lo, hi)
+	}
+	return n, nil
+}
+
+// A collector collects the lines reported by Grep, with ctxLines lines
+// of context, as API results. A line of context between two matches
+// goes after the first if it is within ctxLines of it and before the
+// second otherwise.
+type collector struct {
+	ctxLines int
+	results  []apiResult
+	name     string    // file of the last result or context line
+	file     int       // index in results of the first result for name
+	pending  []apiLine // context not yet assigned to a result
+}
+
+func (c *collector) add(l *regexp.Lines) {
+	if l.Name != c.name {
+		c.name = l.Name
+		c.file = len(c.results)
+		c.pending = nil
+	}
+	text := strings.TrimSuffix(string(l.Text), "\n")
+	switch {
+	case l.Lineno == 0:
+		// A file that matches by name alone.
+		c.results = append(c.results, apiResult{Path: l.Name})
+	case l.Context:
+		line := apiLine{l.Lineno, text}
+		if n := len(c.results); n > c.file {
+			prev := &c.results[n-1]
+			if line.Line < prev.Line+prev.lines+c.ctxLines && c.pending == nil {
+				prev.After = append(prev.After, line)
+				return
+			}
+		}
+		c.pending = append(c.pending, line)
+	default:
+		res := apiResult{
+			Path:   l.Name,
+			Line:   l.Lineno,
+			Offset: l.Offset,
+			Text:   text,
+			Before: c.pending,
+			lines:  strings.Count(text, "\n") + 1,
+		}
+		for _, m := range l.Submatches {
+			res.Submatches = append(res.Submatches, apiSpan{m[0], m[1]})
+		}
+		c.results = append(c.results, res)
+		c.pending = nil
+	}
+}
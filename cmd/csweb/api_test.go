@@ -0,0 +1,179 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/index"
+)
+
+var apiFiles = map[string]string{
+	"a.go":      "package a\n\nfunc F() {}\n\nfunc G() {}\n",
+	"a_test.go": "package a\n\nfunc TestF() {}\n",
+	"b.txt":     "no code here\n",
+	"C.md":      "x\n",
+}
+
+// newTestServer indexes apiFiles, written to a temporary directory,
+// and returns a server for the index and the directory.
+func newTestServer(t *testing.T) (*server, string) {
+	dir := t.TempDir()
+	w := index.Create(filepath.Join(dir, "index"))
+	for _, name := range []string{"a.go", "a_test.go", "b.txt", "C.md"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(apiFiles[name]), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	s := &server{
+		ix:         index.Open(filepath.Join(dir, "index")),
+		encodings:  new(charset.Map),
+		maxResults: 10,
+		timeout:    10 * time.Second,
+	}
+	return s, dir
+}
+
+func apiGet(t *testing.T, s *server, params url.Values, v any) int {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/search?"+params.Encode(), nil)
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: %v\n%s", req.URL, err, rec.Body)
+	}
+	return rec.Code
+}
+
+func TestAPISearch(t *testing.T) {
+	s, dir := newTestServer(t)
+	a := filepath.Join(dir, "a.go")
+	aTest := filepath.Join(dir, "a_test.go")
+	b := filepath.Join(dir, "b.txt")
+	c := filepath.Join(dir, "C.md")
+	tests := []struct {
+		params url.Values
+		want   apiResponse
+	}{
+		{
+			url.Values{"q": {"func"}},
+			apiResponse{Limit: 10, Results: []apiResult{
+				{Path: a, Line: 3, Offset: 11, Text: "func F() {}", Submatches: []apiSpan{{0, 4}}},
+				{Path: a, Line: 5, Offset: 24, Text: "func G() {}", Submatches: []apiSpan{{0, 4}}},
+				{Path: aTest, Line: 3, Offset: 11, Text: "func TestF() {}", Submatches: []apiSpan{{0, 4}}},
+			}},
+		},
+		{
+			url.Values{"q": {"func"}, "context": {"1"}},
+			apiResponse{Limit: 10, Results: []apiResult{
+				{Path: a, Line: 3, Offset: 11, Text: "func F() {}", Submatches: []apiSpan{{0, 4}},
+					Before: []apiLine{{2, ""}}, After: []apiLine{{4, ""}}},
+				{Path: a, Line: 5, Offset: 24, Text: "func G() {}", Submatches: []apiSpan{{0, 4}}},
+				{Path: aTest, Line: 3, Offset: 11, Text: "func TestF() {}", Submatches: []apiSpan{{0, 4}},
+					Before: []apiLine{{2, ""}}},
+			}},
+		},
+		{
+			url.Values{"q": {"func"}, "limit": {"1"}, "offset": {"1"}},
+			apiResponse{Offset: 1, Limit: 1, More: true, Results: []apiResult{
+				{Path: a, Line: 5, Offset: 24, Text: "func G() {}", Submatches: []apiSpan{{0, 4}}},
+			}},
+		},
+		{
+			url.Values{"q": {"func"}, "limit": {"1"}, "offset": {"2"}},
+			apiResponse{Offset: 2, Limit: 1, Results: []apiResult{
+				{Path: aTest, Line: 3, Offset: 11, Text: "func TestF() {}", Submatches: []apiSpan{{0, 4}}},
+			}},
+		},
+		{
+			url.Values{"q": {"func"}, "offset": {"5"}},
+			apiResponse{Offset: 5, Limit: 10, Results: []apiResult{}},
+		},
+		{
+			url.Values{"q": {"F"}, "file": {`_test\.go$`}},
+			apiResponse{Limit: 10, Results: []apiResult{
+				{Path: aTest, Line: 3, Offset: 11, Text: "func TestF() {}", Submatches: []apiSpan{{9, 10}}},
+			}},
+		},
+		{
+			// The file regexp is case-sensitive.
+			url.Values{"q": {"x"}, "file": {`c\.md$`}},
+			apiResponse{Limit: 10, Results: []apiResult{}},
+		},
+		{
+			url.Values{"q": {"x"}, "file": {`C\.md$`}},
+			apiResponse{Limit: 10, Results: []apiResult{
+				{Path: c, Line: 1, Offset: 0, Text: "x", Submatches: []apiSpan{{0, 1}}},
+			}},
+		},
+		{
+			url.Values{"q": {"package"}, "exclude": {"*_test.go"}},
+			apiResponse{Limit: 10, Results: []apiResult{
+				{Path: a, Line: 1, Offset: 0, Text: "package a", Submatches: []apiSpan{{0, 7}}},
+			}},
+		},
+		{
+			url.Values{"q": {`file:\.txt$`}},
+			apiResponse{Limit: 10, Results: []apiResult{{Path: b}}},
+		},
+		{
+			url.Values{"q": {`TestF or file:\.txt$`}},
+			apiResponse{Limit: 10, Results: []apiResult{
+				{Path: aTest, Line: 3, Offset: 11, Text: "func TestF() {}", Submatches: []apiSpan{{5, 10}}},
+				{Path: b},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		var got apiResponse
+		if code := apiGet(t, s, tt.params, &got); code != http.StatusOK {
+			t.Errorf("%v: status %d, want 200", tt.params, code)
+			continue
+		}
+		tt.want.Query = tt.params.Get("q")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v:\nhave %+v\nwant %+v", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, params := range []url.Values{
+		{},
+		{"q": {"("}},
+		{"q": {"a("}},
+		{"q": {"func"}, "file": {"("}},
+		{"q": {"func"}, "exclude": {"["}},
+		{"q": {"func"}, "context": {"-1"}},
+		{"q": {"func"}, "context": {"x"}},
+		{"q": {"func"}, "limit": {"0"}},
+		{"q": {"func"}, "limit": {"11"}},
+		{"q": {"func"}, "offset": {"-1"}},
+		{"q": {"func"}, "offset": {"11"}},
+	} {
+		var got apiError
+		if code := apiGet(t, s, params, &got); code != http.StatusBadRequest || got.Error == "" {
+			t.Errorf("%v: status %d, error %q, want 400 with an error", params, code, got.Error)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/query"
+	"github.com/google/codesearch/regexp"
+)
+
+var usageMessage = `usage: csweb [-http addr] [-max-results n] [-timeout d] [-encoding glob=enc]
+
+Csweb serves a web page for searching the index used by csearch.
+It opens the index once, when it starts, so it must be restarted
+to see the changes made by a later run of cindex.
+
+The search box takes a query, as for csearch -query: a space-separated
+list of terms, all of which must match, such as
+
+	lang:go -file:_test\.go$ (MarshalJSON or UnmarshalJSON)
+
+Each result links to a page showing the whole file, with the matches
+highlighted and the page scrolled to the matching line. Csweb shows
+only files in the index.
+
+Programs can search the index using the JSON API at /api/search,
+which takes the parameters
+
+	q        the query, as in the search box
+	file     a regular expression that file names must match (case-sensitive)
+	exclude  a glob naming files to skip, as in csearch -exclude (repeatable)
+	context  lines of context to return before and after each match (default 0)
+	limit    results to return (default 100, at most -max-results)
+	offset   results to skip, for paging (at most -max-results)
+
+and returns an object listing the matching lines:
+
+	{"query":"func","offset":0,"limit":100,"more":false,"results":[
+		{"path":"/home/you/src/a.go","line":3,"offset":11,"text":"func F() {}",
+			"submatches":[{"start":0,"end":4}],
+			"before":[{"line":2,"text":""}],"after":[{"line":4,"text":""}]},
+		...]}
+
+A result's offset is the byte offset of the line in the file, and
+its submatches are byte offsets in the line, both counting the file
+as converted to UTF-8, without any byte order mark, so that in a
+UTF-8 file that begins with a mark, offsets are 3 less than in the file,
+as are submatches on line 1. A file that matches by
+name alone, as every file matching file:\.txt$ does and a file with
+no lines matching foo may for foo or file:\.txt$, is reported as a
+result with line 0. If more results follow those returned, "more" is true,
+and if the search stopped early, "incomplete" says why.
+Each request searches again from the first result, so the offset is
+limited, like the limit, to bound the work a single request can cause.
+A bad request gets status 400 and an object {"error":"message"}.
+
+The -http flag sets the address to listen on (default localhost:8080).
+The -max-results flag stops each search after n matching lines
+(default 1000), and the -timeout flag stops it after the duration d
+(default 10s); the page then says that the results are incomplete.
+The -encoding flag names the encoding of files whose names match glob
+and that do not begin with a byte order mark, as in cindex and csearch.
+Without the flag, csweb uses the rules that cindex recorded in the index.
+
+Csweb uses the index stored in $CSEARCHINDEX or, if that variable is unset or
+empty, $HOME/.csearchindex.
+`
+
+func usage() {
+	fmt.Fprintf(os.Stderr, usageMessage)
+	os.Exit(2)
+}
+
+var (
+	httpAddr   = flag.String("http", "localhost:8080", "serve HTTP on `addr`")
+	maxResults = flag.Int("max-results", 1000, "stop a search after `n` matching lines")
+	timeout    = flag.Duration("timeout", 10*time.Second, "stop a search after duration `d`")
+)
+
+func main() {
+	log.SetPrefix("csweb: ")
+	var encodings charset.Map
+	encodings.AddFlags()
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 {
+		usage()
+	}
+
+	s := &server{
+		ix:         index.Open(index.File()),
+		encodings:  &encodings,
+		maxResults: *maxResults,
+		timeout:    *timeout,
+	}
+	if len(encodings.Rules()) == 0 {
+		// Convert files as cindex did.
+		s.encodings = s.ix.Encodings()
+	}
+	log.Printf("serving on http://%s/", *httpAddr)
+	log.Fatal(http.ListenAndServe(*httpAddr, s.handler()))
+}
+
+// A server serves searches of an index.
+type server struct {
+	ix         *index.Index
+	encodings  *charset.Map
+	maxResults int           // stop a search after this many matching lines
+	timeout    time.Duration // stop a search after this long
+}
+
+// handler returns the HTTP handler for s.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.serveSearch)
+	mux.HandleFunc("GET /show/", s.serveShow)
+	mux.HandleFunc("GET /api/search", s.serveAPI)
+	return mux
+}
+
+// A page holds the data for pageTemplate.
+type page struct {
+	Title   string
+	Query   string        // query in the search box
+	Error   string        // error to show, if any
+	Results template.HTML // search results, as printed by Grep
+	Name    string        // file shown
+	Lines   []line        // lines of the file shown
+}
+
+// A line is a line of a file shown, with its matches highlighted.
+type line struct {
+	N    int
+	Text template.HTML
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+pre { font-family: monospace; }
+#query { width: 40em; }
+.error, .incomplete { color: #a00; }
+.file a { color: #888; text-decoration: none; }
+.file :target { background: #ffd; }
+mark { background: #fe8; }
+</style>
+</head>
+<body>
+<form action="/" method="get">
+<input id="query" name="q" value="{{.Query}}" autofocus>
+<input type="submit" value="Search">
+</form>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+{{with .Results}}<pre>{{.}}</pre>{{end}}
+{{with .Name}}<h2>{{.}}</h2>{{end}}
+{{with .Lines}}<pre class="file">{{range .}}<span id="L{{.N}}"><a href="#L{{.N}}">{{printf "%5d" .N}}</a>  {{.Text}}</span>
+{{end}}</pre>{{end}}
+</body>
+</html>
+`))
+
+func render(w http.ResponseWriter, code int, p *page) {
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, p); err != nil {
+		log.Print(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// serveSearch serves the search form and, given a query q, its results.
+func (s *server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.FormValue("q")
+	p := &page{Title: "csweb", Query: q}
+	if q == "" {
+		render(w, http.StatusOK, p)
+		return
+	}
+	p.Title = q + " - csweb"
+	e, err := query.Parse(q, query.CaseAuto)
+	if err != nil {
+		p.Error = err.Error()
+		render(w, http.StatusBadRequest, p)
+		return
+	}
+	search, err := query.Compile(e)
+	if err != nil {
+		p.Error = err.Error()
+		render(w, http.StatusBadRequest, p)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	var out bytes.Buffer
+	g := &regexp.Grep{
+		Regexp:    search.Regexp,
+		Stdout:    &out,
+		Stderr:    &logWriter{},
+		HTML:      true,
+		Limit:     s.maxResults,
+		Encodings: s.encodings,
+	}
+	s.search(ctx, g, search, nil)
+	g.Finish()
+	if !g.Match {
+		p.Error = "no results"
+	}
+	p.Results = template.HTML(out.String())
+	render(w, http.StatusOK, p)
+}
+
+// search runs the search, printing the results with g.
+// It skips files matching exclude, if it is not nil.
+func (s *server) search(ctx context.Context, g *regexp.Grep, search *query.Search, exclude *regexp.Exclude) {
+	post, err := s.ix.PostingQueryContext(ctx, search.Query, search.Roots()...)
+	if err != nil {
+		g.Incomplete = err
+		return
+	}
+	query.Grep(ctx, g, search, func(yield func(string) bool) {
+		for _, fileid := range post {
+			name := s.ix.Name(fileid).String()
+			if exclude != nil && exclude.Match(name) {
+				continue
+			}
+			if !yield(name) {
+				return
+			}
+		}
+	})
+}
+
+// serveShow serves a file in the index, with the matches of the
+// regular expression q highlighted.
+func (s *server) serveShow(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/show/")
+	if !filepath.IsAbs(name) {
+		name = "/" + name
+	}
+	name = filepath.FromSlash(name)
+	p := &page{Title: name + " - csweb", Name: name}
+	if _, ok := s.ix.Lookup(index.MakePath(name)); !ok {
+		p.Error = "file not in index"
+		render(w, http.StatusNotFound, p)
+		return
+	}
+	var o index.Opener
+	defer o.Close()
+	f, err := o.Open(name)
+	if err != nil {
+		p.Error = err.Error()
+		render(w, http.StatusNotFound, p)
+		return
+	}
+	data, err := io.ReadAll(charset.NewReader(f, s.encodings.Lookup(name)))
+	f.Close()
+	if err != nil {
+		p.Error = err.Error()
+		render(w, http.StatusInternalServerError, p)
+		return
+	}
+
+	var matches [][]int
+	if q := r.FormValue("q"); q != "" {
+		re, err := regexp.Compile(q)
+		if err != nil {
+			p.Error = err.Error()
+		} else {
+			matches = re.FindAllIndex(data, -1)
+		}
+	}
+	off := 0
+	for i, text := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(text) == 0 {
+			break
+		}
+		for len(matches) > 0 && matches[0][1] <= off {
+			matches = matches[1:]
+		}
+		p.Lines = append(p.Lines, line{i + 1, highlight(text, off, matches)})
+		off += len(text)
+	}
+	render(w, http.StatusOK, p)
+}
+
+// highlight returns the HTML for text, which is at offset off
+// in the file, with the parts in the sorted spans matches marked.
+func highlight(text []byte, off int, matches [][]int) template.HTML {
+	text = bytes.TrimSuffix(text, []byte("\n"))
+	var b strings.Builder
+	pos := 0
+	for _, m := range matches {
+		lo, hi := max(m[0]-off, pos), min(m[1]-off, len(text))
+		if lo >= len(text) {
+			break
+		}
+		if lo >= hi {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(string(text[pos:lo])))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(string(text[lo:hi])))
+		b.WriteString("</mark>")
+		pos = hi
+	}
+	b.WriteString(template.HTMLEscapeString(string(text[pos:])))
+	return template.HTML(b.String())
+}
+
+// A logWriter logs what is written to it,
+// such as the errors Grep prints reading files.
+type logWriter struct{}
+
+func (*logWriter) Write(b []byte) (int, error) {
+	log.Print(string(b))
+	return len(b), nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package glob matches file names against the glob patterns
+// taken by the csearch -exclude and cindex -encoding flags.
+package glob
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Clean checks that pattern is a valid shell pattern, as in path.Match,
+// and returns it without any trailing slash.
+func Clean(pattern string) (string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(pattern, "/"), nil
+}
+
+// Split splits the file name into the elements that Match matches.
+func Split(name string) []string {
+	// Index names for files inside zip archives use \x01
+	// to separate the archive name from the file name.
+	name = strings.Map(func(r rune) rune {
+		if r == os.PathSeparator || r == '\x01' {
+			return '/'
+		}
+		return r
+	}, name)
+	return strings.Split(name, "/")
+}
+
+// Match reports whether pattern, as returned by Clean, matches a run
+// of consecutive elements in a file name, as returned by Split,
+// that has as many elements as the pattern. So *_test.go matches
+// test files, vendor matches everything in a vendor directory,
+// and third_party/go matches everything in third_party/go.
+func Match(pattern string, elem []string) bool {
+	n := strings.Count(pattern, "/") + 1
+	for i := 0; i+n <= len(elem); i++ {
+		if ok, _ := path.Match(pattern, strings.Join(elem[i:i+n], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package glob
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*_test.go", "/src/a/x_test.go", true},
+		{"*_test.go", "/src/a/x.go", false},
+		{"vendor", "/src/vendor/x/y.go", true},
+		{"vendor/", "/src/vendor/x/y.go", true},
+		{"vendor", "/src/vendored/y.go", false},
+		{"third_party/go", "/src/third_party/go/x.go", true},
+		{"third_party/go", "/src/third_party/gox/x.go", false},
+		{"*.go", "/src/a.zip\x01a/b.go", true},
+		{"a.zip/a", "/src/a.zip\x01a/b.go", true},
+	} {
+		pattern, err := Clean(tt.pattern)
+		if err != nil {
+			t.Errorf("Clean(%q): %v", tt.pattern, err)
+			continue
+		}
+		if m := Match(pattern, Split(filepath.FromSlash(tt.name))); m != tt.match {
+			t.Errorf("Match(%q, Split(%q)) = %v, want %v", tt.pattern, tt.name, m, tt.match)
+		}
+	}
+	if _, err := Clean("["); err == nil {
+		t.Errorf("Clean(%q) succeeded, want error", "[")
+	}
+}
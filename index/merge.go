@@ -34,6 +34,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"slices"
 )
 
 // An idrange records that the half-open interval [lo, hi) maps to [new, new+hi-lo).
@@ -122,6 +123,9 @@ func Merge(dst, src1, src2 string) {
 		ix.WriteString(magicV1)
 	} else {
 		ix.WriteString(magicV2)
+		for _, rule := range mergeRules(ix1.Encodings().Rules(), ix2.Encodings().Rules()) {
+			ix.WriteString(rule + "\n")
+		}
 	}
 
 	// Merged list of paths.
@@ -381,3 +385,16 @@ func (r *postMapReader) nextId() bool {
 	r.fileid = -1
 	return false
 }
+
+// mergeRules returns the encoding rules for an index merging indexes
+// with rules1 and rules2: the rules of the newer rules2, preceded by
+// the rules1 it does not repeat, so that rules2 wins any conflict.
+func mergeRules(rules1, rules2 []string) []string {
+	var rules []string
+	for _, r := range rules1 {
+		if !slices.Contains(rules2, r) {
+			rules = append(rules, r)
+		}
+	}
+	return append(rules, rules2...)
+}
@@ -32,6 +32,12 @@ func mmapFile(f *os.File) mmapData {
 	if err != nil {
 		log.Fatalf("MapViewOfFile %s: %v", f.Name(), err)
 	}
+	// The view keeps the mapping alive until it is unmapped.
+	syscall.CloseHandle(h)
 	data := (*[1 << 30]byte)(unsafe.Pointer(addr))
 	return mmapData{f, data[:size]}
 }
+
+func munmap(d []byte) error {
+	return syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&d[0])))
+}
@@ -0,0 +1,67 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+)
+
+// An Opener opens indexed files, including files inside zip archives,
+// which the index names "file.zip\x01name".
+// It keeps the most recently used zip archive open,
+// since the index lists all the files in an archive consecutively.
+// An Opener is not safe for concurrent use.
+type Opener struct {
+	file   string
+	reader *zip.ReadCloser
+	files  map[string]*zip.File
+}
+
+// Open opens the named file for reading.
+// The caller must close the file, and for a file in a zip archive,
+// must finish reading it before opening a file in a different archive.
+func (z *Opener) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	i := strings.Index(name, ".zip\x01")
+	if i < 0 {
+		return nil, err
+	}
+	zfile, zname := name[:i+4], name[i+5:]
+	if zfile != z.file {
+		z.Close()
+		z.file = zfile
+		z.reader, err = zip.OpenReader(zfile)
+		if err != nil {
+			return nil, err
+		}
+		z.files = make(map[string]*zip.File)
+		for _, file := range z.reader.File {
+			z.files[file.Name] = file
+		}
+	}
+	file := z.files[zname]
+	if file == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return file.Open()
+}
+
+// Close closes the zip archive the Opener has open, if any.
+func (z *Opener) Close() error {
+	var err error
+	if z.reader != nil {
+		err = z.reader.Close()
+	}
+	z.file = ""
+	z.reader = nil
+	z.files = nil
+	return err
+}
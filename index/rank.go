@@ -0,0 +1,43 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "sort"
+
+// A FileMatch summarizes the matches a search found in one file,
+// for ranking the files against each other.
+type FileMatch struct {
+	Name      string  // file name, as in the index
+	Size      int64   // bytes searched in the file
+	Matches   int     // number of matches
+	Defs      int     // number of matches on lines that look like definitions
+	NameMatch bool    // the pattern matches the base name of the file
+	Score     float64 // set by Rank
+}
+
+// A Scorer scores a file's matches. Higher scores rank first.
+type Scorer interface {
+	Score(f *FileMatch) float64
+}
+
+// A ScorerFunc is a function used as a Scorer.
+type ScorerFunc func(f *FileMatch) float64
+
+func (s ScorerFunc) Score(f *FileMatch) float64 { return s(f) }
+
+// Rank sets the Score of each file using s and sorts the files
+// from highest to lowest score, breaking ties by name.
+func Rank(files []*FileMatch, s Scorer) {
+	for _, f := range files {
+		f.Score = s.Score(f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		fi, fj := files[i], files[j]
+		if fi.Score != fj.Score {
+			return fi.Score > fj.Score
+		}
+		return fi.Name < fj.Name
+	})
+}
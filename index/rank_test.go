@@ -0,0 +1,47 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"slices"
+	"testing"
+)
+
+func rankNames(files []*FileMatch) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestRank(t *testing.T) {
+	files := []*FileMatch{
+		{Name: "/src/a/b/c/deep.go", Size: 1000},
+		{Name: "/src/a/def.go", Size: 1000},
+		{Name: "/src/a/few.go", Size: 1000},
+		{Name: "/src/a/dense.go", Size: 30},
+		{Name: "/src/a/big.go", Size: 2000},
+	}
+
+	// Ties sort by name.
+	bySize := ScorerFunc(func(f *FileMatch) float64 { return float64(f.Size) })
+	Rank(files, bySize)
+	want := []string{
+		"/src/a/big.go",
+		"/src/a/b/c/deep.go",
+		"/src/a/def.go",
+		"/src/a/few.go",
+		"/src/a/dense.go",
+	}
+	if got := rankNames(files); !slices.Equal(got, want) {
+		t.Errorf("Rank(bySize):\nhave %q\nwant %q", got, want)
+	}
+	for i, f := range files {
+		if f.Score != float64(f.Size) {
+			t.Errorf("files[%d].Score = %g, want %d", i, f.Score, f.Size)
+		}
+	}
+}
@@ -9,6 +9,7 @@ package index
 // An index stored on disk has the format:
 //
 //	"csearch index 2\n"
+//	encoding rules
 //	list of roots
 //	list of names
 //	list of posting lists
@@ -16,6 +17,13 @@ package index
 //	posting list index
 //	trailer
 //
+// The encoding rules are the rules of IndexWriter.Encodings, by which
+// files were converted to UTF-8 for indexing, so that searches can
+// convert them the same way. Each rule is written as glob=enc followed
+// by a newline (see charset.Map.Set), and the rules end where the list
+// of roots begins. The list is usually empty. Readers that predate it
+// find the list of roots by its offset in the trailer and never see it.
+//
 // The list of roots and list of names are sorted (by Path.Cmp)
 // sequences of prefix-compressed paths. Each path is encoded
 // as a varint number of prefix bytes to copy from the previous
@@ -146,6 +154,7 @@ package index
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"iter"
@@ -154,6 +163,10 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/codesearch/charset"
 )
 
 const (
@@ -173,6 +186,7 @@ type Index struct {
 	Verbose      bool
 	name         string
 	data         mmapData
+	modTime      time.Time // modification time of the file when opened
 	version      int
 	pathData     int
 	numPath      int
@@ -196,6 +210,9 @@ func (ix *Index) PrintStats() {
 func Open(file string) *Index {
 	mm := mmap(file)
 	ix := &Index{name: file, data: mm}
+	if st, err := mm.f.Stat(); err == nil {
+		ix.modTime = st.ModTime()
+	}
 	if len(mm.d) < len(trailerMagicV1) {
 		ix.corrupt()
 	}
@@ -241,6 +258,36 @@ func Open(file string) *Index {
 	return ix
 }
 
+// Close unmaps the index and closes the index file.
+// The Index must not be used after Close.
+func (ix *Index) Close() error {
+	var err error
+	if ix.data.d != nil {
+		err = munmap(ix.data.d)
+	}
+	if err1 := ix.data.f.Close(); err == nil {
+		err = err1
+	}
+	ix.data.d = nil
+	return err
+}
+
+// Changed reports whether the index file has been replaced or
+// modified since ix was opened, as when cindex rewrites it.
+// If the file cannot be examined, perhaps because it is being
+// replaced, Changed reports false.
+func (ix *Index) Changed() bool {
+	open, err := ix.data.f.Stat()
+	if err != nil {
+		return false
+	}
+	cur, err := os.Stat(ix.name)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(open, cur) || !cur.ModTime().Equal(ix.modTime) || cur.Size() != int64(len(ix.data.d))
+}
+
 // slice returns the slice of index data starting at the given byte offset.
 // If n >= 0, the slice must have length at least n and is truncated to length n.
 func (ix *Index) slice(off int, n int) []byte {
@@ -279,6 +326,27 @@ func (ix *Index) Roots() *PathReader {
 	return NewPathReader(ix.version, ix.slice(ix.pathData, ix.nameData-ix.pathData), ix.numPath)
 }
 
+// Encodings returns the encoding rules recorded in the index:
+// the IndexWriter.Encodings by which its files were converted to UTF-8.
+func (ix *Index) Encodings() *charset.Map {
+	m := new(charset.Map)
+	if ix.version < 2 {
+		return m
+	}
+	if ix.pathData < len(magicV2) {
+		ix.corrupt()
+	}
+	rules := string(ix.slice(len(magicV2), ix.pathData-len(magicV2)))
+	for rules != "" {
+		rule, rest, ok := strings.Cut(rules, "\n")
+		if !ok || m.Set(rule) != nil {
+			ix.corrupt()
+		}
+		rules = rest
+	}
+	return m
+}
+
 // Name returns the name corresponding to the given fileid.
 func (ix *Index) Name(fileid int) Path {
 	return ix.NamesAt(fileid, fileid+1).Path()
@@ -408,10 +476,11 @@ type postReader struct {
 	offset   int
 	fileid   int
 	restrict []int
+	ranges   idRanges
 	delta    deltaReader
 }
 
-func (r *postReader) init(ix *Index, trigram uint32, restrict []int) {
+func (r *postReader) init(ix *Index, trigram uint32, restrict []int, ranges idRanges) {
 	count, offset := ix.findList(trigram)
 	if count == 0 {
 		return
@@ -422,6 +491,7 @@ func (r *postReader) init(ix *Index, trigram uint32, restrict []int) {
 	r.fileid = -1
 	r.delta.init(r.ix, ix.slice(ix.postData+offset+3, -1))
 	r.restrict = restrict
+	r.ranges = ranges
 }
 
 func (r *postReader) max() int {
@@ -439,6 +509,21 @@ func (r *postReader) next() bool {
 			r.ix.corrupt()
 		}
 		r.fileid += delta
+		if r.ranges != nil {
+			i := 0
+			for i < len(r.ranges) && r.ranges[i][1] <= r.fileid {
+				i++
+			}
+			r.ranges = r.ranges[i:]
+			if len(r.ranges) == 0 {
+				// Past the last range: the rest of the list does not matter.
+				r.ix = nil
+				return false
+			}
+			if r.fileid < r.ranges[0][0] {
+				continue
+			}
+		}
 		if r.restrict != nil {
 			i := 0
 			for i < len(r.restrict) && r.restrict[i] < r.fileid {
@@ -499,12 +584,12 @@ func (r *allPostReader) next() (postEntry, bool) {
 }
 
 func (ix *Index) PostingList(trigram uint32) []int {
-	return ix.postingList(trigram, nil)
+	return ix.postingList(trigram, nil, nil)
 }
 
-func (ix *Index) postingList(trigram uint32, restrict []int) []int {
+func (ix *Index) postingList(trigram uint32, restrict []int, ranges idRanges) []int {
 	var r postReader
-	r.init(ix, trigram, restrict)
+	r.init(ix, trigram, restrict, ranges)
 	x := make([]int, 0, r.max())
 	for r.next() {
 		x = append(x, r.fileid)
@@ -513,12 +598,12 @@ func (ix *Index) postingList(trigram uint32, restrict []int) []int {
 }
 
 func (ix *Index) PostingAnd(list []int, trigram uint32) []int {
-	return ix.postingAnd(list, trigram, nil)
+	return ix.postingAnd(list, trigram, nil, nil)
 }
 
-func (ix *Index) postingAnd(list []int, trigram uint32, restrict []int) []int {
+func (ix *Index) postingAnd(list []int, trigram uint32, restrict []int, ranges idRanges) []int {
 	var r postReader
-	r.init(ix, trigram, restrict)
+	r.init(ix, trigram, restrict, ranges)
 	x := list[:0]
 	i := 0
 	for r.next() {
@@ -535,12 +620,12 @@ func (ix *Index) postingAnd(list []int, trigram uint32, restrict []int) []int {
 }
 
 func (ix *Index) PostingOr(list []int, trigram uint32) []int {
-	return ix.postingOr(list, trigram, nil)
+	return ix.postingOr(list, trigram, nil, nil)
 }
 
-func (ix *Index) postingOr(list []int, trigram uint32, restrict []int) []int {
+func (ix *Index) postingOr(list []int, trigram uint32, restrict []int, ranges idRanges) []int {
 	var r postReader
-	r.init(ix, trigram, restrict)
+	r.init(ix, trigram, restrict, ranges)
 	x := make([]int, 0, len(list)+r.max())
 	i := 0
 	for r.next() {
@@ -559,59 +644,213 @@ func (ix *Index) postingOr(list []int, trigram uint32, restrict []int) []int {
 }
 
 func (ix *Index) PostingQuery(q *Query) []int {
-	return ix.postingQuery(q, nil)
+	list, _ := ix.postingQuery(context.Background(), q, nil, nil)
+	return list
 }
 
-func (ix *Index) postingQuery(q *Query, restrict []int) (ret []int) {
+// PostingQueryPath is like PostingQuery but only considers files
+// in the directory trees rooted at the given prefixes.
+// Each tree is a contiguous range of file IDs (see PathRange),
+// found by binary search of the name list. Each posting list the query
+// reads is decoded only as far as the end of the last range, and the
+// entries outside the ranges are dropped as they are decoded, so no
+// list of the files in the trees is ever built, except to answer
+// a query that matches every file.
+// If no prefixes are given, PostingQueryPath considers all files.
+func (ix *Index) PostingQueryPath(q *Query, prefixes ...Path) []int {
+	list, _ := ix.postingQuery(context.Background(), q, nil, ix.pathRanges(prefixes))
+	return list
+}
+
+// PostingQueryContext is like PostingQueryPath but checks ctx
+// before reading each posting list. If ctx is canceled or its
+// deadline passes, PostingQueryContext stops and returns ctx.Err().
+func (ix *Index) PostingQueryContext(ctx context.Context, q *Query, prefixes ...Path) ([]int, error) {
+	return ix.postingQuery(ctx, q, nil, ix.pathRanges(prefixes))
+}
+
+// PostingAtLeast returns the files containing at least n of the
+// given trigrams, which must be distinct, as computed by FuzzyTrigrams.
+// Like PostingQueryContext, it considers only files in the directory trees
+// rooted at the given prefixes, if any, and checks ctx before reading
+// each posting list.
+func (ix *Index) PostingAtLeast(ctx context.Context, trigrams []string, n int, prefixes ...Path) ([]int, error) {
+	ranges := ix.pathRanges(prefixes)
+	if n <= 0 {
+		return ix.postingQuery(ctx, &Query{Op: QAll}, nil, ranges)
+	}
+	// Merge the lists, counting how many contain each file.
+	var ids []int
+	for _, t := range trigrams {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tri := uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2])
+		ids = append(ids, ix.postingList(tri, nil, ranges)...)
+	}
+	sort.Ints(ids)
+	var list []int
+	for i := 0; i < len(ids); {
+		j := i + 1
+		for j < len(ids) && ids[j] == ids[i] {
+			j++
+		}
+		if j-i >= n {
+			list = append(list, ids[i])
+		}
+		i = j
+	}
+	return list, nil
+}
+
+// PathRange returns the range [lo, hi) of file IDs for the names
+// that are prefix itself or lie in the directory tree rooted at prefix.
+// Since the name list is sorted by Path.Compare, which orders
+// x/y before x.foo, those names are contiguous.
+func (ix *Index) PathRange(prefix Path) (lo, hi int) {
+	isSep := func(c byte) bool { return c == '/' || c == os.PathSeparator }
+	for len(prefix.s) > 1 && isSep(prefix.s[len(prefix.s)-1]) {
+		prefix.s = prefix.s[:len(prefix.s)-1]
+	}
+	inTree := func(p Path) bool { return p.HasPathPrefix(prefix) }
+	if prefix.s != "" && isSep(prefix.s[len(prefix.s)-1]) {
+		// A file system root like / contains every name beginning with it.
+		inTree = func(p Path) bool { return strings.HasPrefix(p.s, prefix.s) }
+	}
+	lo = sort.Search(ix.numName, func(i int) bool {
+		return ix.Name(i).Compare(prefix) >= 0
+	})
+	hi = lo + sort.Search(ix.numName-lo, func(i int) bool {
+		return !inTree(ix.Name(lo + i))
+	})
+	return lo, hi
+}
+
+// Lookup returns the file ID of the named file
+// and reports whether the index contains it.
+func (ix *Index) Lookup(name Path) (fileid int, ok bool) {
+	i := sort.Search(ix.numName, func(i int) bool {
+		return ix.Name(i).Compare(name) >= 0
+	})
+	if i < ix.numName && ix.Name(i) == name {
+		return i, true
+	}
+	return 0, false
+}
+
+// An idRanges is a sorted list of disjoint ranges [lo, hi) of file IDs.
+// A nil idRanges means no restriction; an empty non-nil one allows no files.
+type idRanges [][2]int
+
+// ids returns the file IDs in the ranges.
+func (rs idRanges) ids() []int {
+	ids := []int{}
+	for _, r := range rs {
+		for id := r[0]; id < r[1]; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// pathRanges returns the ranges of file IDs in the directory trees
+// rooted at the given prefixes, for use in postingQuery.
+// If there are no prefixes, pathRanges returns nil, meaning no restriction.
+func (ix *Index) pathRanges(prefixes []Path) idRanges {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	var ranges idRanges
+	for _, p := range prefixes {
+		lo, hi := ix.PathRange(p)
+		if lo < hi {
+			ranges = append(ranges, [2]int{lo, hi})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+	merged := idRanges{}
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			// Overlapping or adjacent: extend the previous range.
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// postingQuery returns the files that may match q.
+// If restrict is not nil, only the files it lists are considered,
+// and if ranges is not nil, only the files in those ranges.
+func (ix *Index) postingQuery(ctx context.Context, q *Query, restrict []int, ranges idRanges) ([]int, error) {
 	var list []int
 	switch q.Op {
 	case QNone:
 		// nothing
 	case QAll:
 		if restrict != nil {
-			return restrict
+			return restrict, nil
+		}
+		if ranges != nil {
+			return ranges.ids(), nil
 		}
 		list = make([]int, ix.numName)
 		for i := range list {
 			list[i] = i
 		}
-		return list
+		return list, nil
 	case QAnd:
 		for _, t := range q.Trigram {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
 			tri := uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2])
 			if list == nil {
-				list = ix.postingList(tri, restrict)
+				list = ix.postingList(tri, restrict, ranges)
 			} else {
-				list = ix.postingAnd(list, tri, restrict)
+				list = ix.postingAnd(list, tri, restrict, ranges)
 			}
 			if len(list) == 0 {
-				return nil
+				return nil, nil
 			}
 		}
 		for _, sub := range q.Sub {
 			if list == nil {
 				list = restrict
 			}
-			list = ix.postingQuery(sub, list)
+			var err error
+			list, err = ix.postingQuery(ctx, sub, list, ranges)
+			if err != nil {
+				return nil, err
+			}
 			if len(list) == 0 {
-				return nil
+				return nil, nil
 			}
 		}
 	case QOr:
 		for _, t := range q.Trigram {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
 			tri := uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2])
 			if list == nil {
-				list = ix.postingList(tri, restrict)
+				list = ix.postingList(tri, restrict, ranges)
 			} else {
-				list = ix.postingOr(list, tri, restrict)
+				list = ix.postingOr(list, tri, restrict, ranges)
 			}
 		}
 		for _, sub := range q.Sub {
-			list1 := ix.postingQuery(sub, restrict)
+			list1, err := ix.postingQuery(ctx, sub, restrict, ranges)
+			if err != nil {
+				return nil, err
+			}
 			list = mergeOr(list, list1)
 		}
 	}
-	return list
+	return list, nil
 }
 
 func mergeOr(l1, l2 []int) []int {
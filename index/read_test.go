@@ -5,9 +5,14 @@
 package index
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
+
+	"github.com/google/codesearch/charset"
 )
 
 var postFiles = map[string]string{
@@ -51,3 +56,266 @@ func TestTrivialPosting(t *testing.T) {
 		t.Errorf("PostingList(Goo|Sea) = %v, want [1 2 3]", l)
 	}
 }
+
+var pathFiles = map[string]string{
+	"/a/x":     "Google Code Search",
+	"/a.b/y":   "Google Code Project Hosting",
+	"/b":       "Google Web Search",
+	"/b/c/d":   "Google Code Search",
+	"/b/c/e":   "Google Code Project Hosting",
+	"/b/c.d/f": "Google Code Search",
+	"/b/cd/g":  "Google Web Search",
+	"/bc":      "Google Code Search",
+}
+
+func TestPathRange(t *testing.T) {
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	out := f.Name()
+	// buildIndex adds files in string order, which differs from
+	// Path.Compare order for these names, so add them by hand.
+	w := Create(out)
+	var names []Path
+	for name := range pathFiles {
+		names = append(names, MakePath(name))
+	}
+	slices.SortFunc(names, Path.Compare)
+	for _, name := range names {
+		data := pathFiles[name.String()]
+		w.Add(name.String(), &stringFile{strings.NewReader(data), name.String(), int64(len(data))})
+	}
+	w.Flush()
+	ix := Open(out)
+	checkFiles(t, ix, "/a/x", "/a.b/y", "/b", "/b/c/d", "/b/c/e", "/b/c.d/f", "/b/cd/g", "/bc")
+
+	tests := []struct {
+		prefix string
+		lo, hi int
+	}{
+		{"/", 0, 8},
+		{"/a", 0, 1},
+		{"/a.b", 1, 2},
+		{"/b", 2, 7},
+		{"/b/", 2, 7},
+		{"/b/c", 3, 5},
+		{"/b/c/e", 4, 5},
+		{"/b/c.", 5, 5},
+		{"/bc", 7, 8},
+		{"/c", 8, 8},
+		{"/0", 0, 0},
+	}
+	for _, tt := range tests {
+		lo, hi := ix.PathRange(MakePath(tt.prefix))
+		if lo != tt.lo || hi != tt.hi {
+			t.Errorf("PathRange(%q) = %d, %d, want %d, %d", tt.prefix, lo, hi, tt.lo, tt.hi)
+		}
+	}
+
+	for i, name := range names {
+		if id, ok := ix.Lookup(name); id != i || !ok {
+			t.Errorf("Lookup(%q) = %d, %v, want %d, true", name, id, ok, i)
+		}
+	}
+	for _, name := range []string{"/", "/a", "/b/c", "/b/c/f", "/c"} {
+		if id, ok := ix.Lookup(MakePath(name)); ok {
+			t.Errorf("Lookup(%q) = %d, true, want false", name, id)
+		}
+	}
+
+	q := &Query{Op: QAnd, Trigram: []string{"Sea"}}
+	if l := ix.PostingQueryPath(q, MakePath("/b")); !slices.Equal(l, []int{2, 3, 5, 6}) {
+		t.Errorf("PostingQueryPath(Sea, /b) = %v, want [2 3 5 6]", l)
+	}
+	if l := ix.PostingQueryPath(q, MakePath("/b/c"), MakePath("/a"), MakePath("/b/c/d")); !slices.Equal(l, []int{0, 3}) {
+		t.Errorf("PostingQueryPath(Sea, /b/c /a /b/c/d) = %v, want [0 3]", l)
+	}
+	if l := ix.PostingQueryPath(&Query{Op: QAll}, MakePath("/b/c")); !slices.Equal(l, []int{3, 4}) {
+		t.Errorf("PostingQueryPath(+, /b/c) = %v, want [3 4]", l)
+	}
+	if l := ix.PostingQueryPath(q, MakePath("/c")); len(l) != 0 {
+		t.Errorf("PostingQueryPath(Sea, /c) = %v, want []", l)
+	}
+	if l := ix.PostingQueryPath(q); !slices.Equal(l, []int{0, 2, 3, 5, 6, 7}) {
+		t.Errorf("PostingQueryPath(Sea) = %v, want [0 2 3 5 6 7]", l)
+	}
+	if l := ix.PostingQueryPath(q, MakePath("/bc"), MakePath("/a")); !slices.Equal(l, []int{0, 7}) {
+		t.Errorf("PostingQueryPath(Sea, /bc /a) = %v, want [0 7]", l)
+	}
+	or := &Query{Op: QOr, Sub: []*Query{q, {Op: QAll}}}
+	if l := ix.PostingQueryPath(or, MakePath("/a.b"), MakePath("/bc")); !slices.Equal(l, []int{1, 7}) {
+		t.Errorf("PostingQueryPath(Sea|+, /a.b /bc) = %v, want [1 7]", l)
+	}
+	and := &Query{Op: QAnd, Sub: []*Query{q, or}}
+	if l := ix.PostingQueryPath(and, MakePath("/b/c")); !slices.Equal(l, []int{3}) {
+		t.Errorf("PostingQueryPath(Sea (Sea|+), /b/c) = %v, want [3]", l)
+	}
+
+	rangeTests := []struct {
+		prefixes []string
+		ranges   idRanges
+	}{
+		{nil, nil},
+		{[]string{"/c"}, idRanges{}},
+		{[]string{"/b/c", "/a", "/b/c/d"}, idRanges{{0, 1}, {3, 5}}},
+		{[]string{"/a.b", "/a", "/b"}, idRanges{{0, 7}}},
+	}
+	for _, tt := range rangeTests {
+		var prefixes []Path
+		for _, p := range tt.prefixes {
+			prefixes = append(prefixes, MakePath(p))
+		}
+		if r := ix.pathRanges(prefixes); !slices.Equal(r, tt.ranges) || (r == nil) != (tt.ranges == nil) {
+			t.Errorf("pathRanges(%q) = %v, want %v", tt.prefixes, r, tt.ranges)
+		}
+	}
+}
+
+func TestPostingQueryContext(t *testing.T) {
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	out := f.Name()
+	buildIndex(out, nil, postFiles)
+	ix := Open(out)
+
+	q := &Query{Op: QAnd, Trigram: []string{"Goo", "Sea"}}
+	l, err := ix.PostingQueryContext(context.Background(), q)
+	if err != nil || !slices.Equal(l, []int{1, 3}) {
+		t.Errorf("PostingQueryContext(Goo&Sea) = %v, %v, want [1 3], nil", l, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l, err = ix.PostingQueryContext(ctx, q)
+	if err != context.Canceled || l != nil {
+		t.Errorf("PostingQueryContext(canceled) = %v, %v, want nil, %v", l, err, context.Canceled)
+	}
+	q = &Query{Op: QOr, Sub: []*Query{{Op: QAnd, Trigram: []string{"Web"}}}}
+	l, err = ix.PostingQueryContext(ctx, q)
+	if err != context.Canceled || l != nil {
+		t.Errorf("PostingQueryContext(canceled, Web) = %v, %v, want nil, %v", l, err, context.Canceled)
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	out := f.Name()
+	files := []struct{ name, data string }{
+		{"/a.txt", "\xFF\xFEG\x00o\x00o\x00g\x00l\x00e\x00"}, // UTF-16LE with byte order mark
+		{"/b.rc", "G\x00o\x00o\x00g\x00l\x00e\x00"},          // UTF-16LE without
+		{"/c.c", "Googl\xE9"},                                // Latin-1
+		{"/d.c", "Google\x00"},                               // binary
+	}
+	var enc charset.Map
+	enc.Set("*.rc=utf-16le")
+	enc.Set("*.c=latin1")
+	w := Create(out)
+	w.Encodings = &enc
+	for _, f := range files {
+		w.Add(f.name, &stringFile{strings.NewReader(f.data), f.name, int64(len(f.data))})
+	}
+	w.Flush()
+	ix := Open(out)
+	checkFiles(t, ix, "/a.txt", "/b.rc", "/c.c")
+
+	q := &Query{Op: QAnd, Trigram: []string{"Goo", "ogl"}}
+	if l := ix.PostingQuery(q); !slices.Equal(l, []int{0, 1, 2}) {
+		t.Errorf("PostingQuery(Goo ogl) = %v, want [0 1 2]", l)
+	}
+	q = &Query{Op: QAnd, Trigram: []string{"gle"}}
+	if l := ix.PostingQuery(q); !slices.Equal(l, []int{0, 1}) {
+		t.Errorf("PostingQuery(gle) = %v, want [0 1]", l)
+	}
+	q = &Query{Op: QAnd, Trigram: []string{"glé"[:3], "lé"}}
+	if l := ix.PostingQuery(q); !slices.Equal(l, []int{2}) {
+		t.Errorf("PostingQuery(glé) = %v, want [2]", l)
+	}
+
+	// The index records the rules, and a merged index records both sets.
+	if rules, want := ix.Encodings().Rules(), []string{"*.rc=utf-16le", "*.c=latin1"}; !slices.Equal(rules, want) {
+		t.Errorf("Encodings().Rules() = %q, want %q", rules, want)
+	}
+	f2, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f2.Name())
+	f3, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f3.Name())
+	var enc2 charset.Map
+	enc2.Set("*.c=utf-8")
+	w = Create(f2.Name())
+	w.Encodings = &enc2
+	w.AddRoots([]Path{MakePath("/e")})
+	w.Add("/e/e.c", &stringFile{strings.NewReader("Google"), "/e/e.c", 6})
+	w.Flush()
+	Merge(f3.Name(), out, f2.Name())
+	ix3 := Open(f3.Name())
+	checkFiles(t, ix3, "/a.txt", "/b.rc", "/c.c", "/e/e.c")
+	if rules, want := ix3.Encodings().Rules(), []string{"*.rc=utf-16le", "*.c=latin1", "*.c=utf-8"}; !slices.Equal(rules, want) {
+		t.Errorf("merged Encodings().Rules() = %q, want %q", rules, want)
+	}
+}
+
+func TestPostingAtLeast(t *testing.T) {
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	out := f.Name()
+	buildIndex(out, nil, postFiles)
+	ix := Open(out)
+
+	tests := []struct {
+		trigrams []string
+		n        int
+		want     []int
+	}{
+		{[]string{"Goo", "Sea", "Web"}, 1, []int{1, 2, 3}},
+		{[]string{"Goo", "Sea", "Web"}, 2, []int{1, 3}},
+		{[]string{"Goo", "Sea", "Web"}, 3, []int{3}},
+		{[]string{"Goo", "Sea", "Web"}, 4, nil},
+		{[]string{"xyz"}, 0, []int{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		l, err := ix.PostingAtLeast(context.Background(), tt.trigrams, tt.n)
+		if err != nil || !slices.Equal(l, tt.want) {
+			t.Errorf("PostingAtLeast(%q, %d) = %v, %v, want %v", tt.trigrams, tt.n, l, err, tt.want)
+		}
+	}
+	l, err := ix.PostingAtLeast(context.Background(), []string{"Goo", "Sea"}, 1, MakePath("file3"))
+	if err != nil || !slices.Equal(l, []int{3}) {
+		t.Errorf("PostingAtLeast(Goo Sea, 1, file3) = %v, %v, want [3]", l, err)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "index")
+	buildIndex(out, nil, postFiles)
+	ix := Open(out)
+	if ix.Changed() {
+		t.Errorf("Changed() = true for new index")
+	}
+
+	// Replace the index the way cindex does, renaming a new file over it.
+	buildIndex(out+"~", nil, trivialFiles)
+	if err := os.Rename(out+"~", out); err != nil {
+		t.Fatal(err)
+	}
+	if !ix.Changed() {
+		t.Errorf("Changed() = false after replacing index")
+	}
+	if l := ix.PostingList(tri("Sea")); !slices.Equal(l, []int{1, 3}) {
+		t.Errorf("PostingList(Sea) after replacing index = %v, want [1 3]", l)
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ix = Open(out)
+	if ix.Changed() {
+		t.Errorf("Changed() = true for reopened index")
+	}
+	if ix.numName != len(trivialFiles) {
+		t.Errorf("reopened index has %d names, want %d", ix.numName, len(trivialFiles))
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
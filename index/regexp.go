@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // A Query is a matching machine, like a regular expression,
@@ -37,6 +38,16 @@ const (
 var allQuery = &Query{Op: QAll}
 var noneQuery = &Query{Op: QNone}
 
+// And returns the query q AND r, possibly reusing q's and r's storage.
+func (q *Query) And(r *Query) *Query {
+	return q.and(r)
+}
+
+// Or returns the query q OR r, possibly reusing q's and r's storage.
+func (q *Query) Or(r *Query) *Query {
+	return q.or(r)
+}
+
 // and returns the query q AND r, possibly reusing q's and r's storage.
 func (q *Query) and(r *Query) *Query {
 	return q.andOr(r, QAnd)
@@ -280,6 +291,16 @@ func (q *Query) andTrigrams(t stringSet) *Query {
 	return q
 }
 
+// Size returns the number of trigrams in q,
+// which is the number of posting lists a search using q reads.
+func (q *Query) Size() int {
+	n := len(q.Trigram)
+	for _, sub := range q.Sub {
+		n += sub.Size()
+	}
+	return n
+}
+
 func (q *Query) String() string {
 	if q == nil {
 		return "?"
@@ -337,6 +358,28 @@ func RegexpQuery(re *syntax.Regexp) *Query {
 	return info.match
 }
 
+// FuzzyTrigrams returns the distinct trigrams in lit and the number n
+// of them that any text within Levenshtein distance k of lit must contain,
+// for use with PostingAtLeast. If n ≤ 0, the trigrams cannot rule out any file.
+//
+// Each inserted, deleted, or substituted character destroys at most
+// w+2 of the trigram occurrences in lit, where w is the length in bytes
+// of the longest character in lit. A distinct trigram is missing from
+// the text only if all its occurrences are destroyed, so at most k(w+2)
+// of the distinct trigrams can be missing.
+func FuzzyTrigrams(lit string, k int) (trigrams []string, n int) {
+	w := 1
+	for _, r := range lit {
+		w = max(w, utf8.RuneLen(r))
+	}
+	set := make(stringSet, 0, len(lit))
+	for i := 0; i+3 <= len(lit); i++ {
+		set = append(set, lit[i:i+3])
+	}
+	set.clean(false)
+	return set, len(set) - k*(w+2)
+}
+
 // A regexpInfo summarizes the results of analyzing a regexp.
 type regexpInfo struct {
 	// canEmpty records whether the regexp matches the empty string
@@ -375,6 +418,31 @@ const (
 	// represent a case-insensitive abc by the set
 	// {abc, abC, aBc, aBC, Abc, AbC, ABc, ABC}.
 	maxSet = 20
+
+	// Character classes with more than maxClass characters
+	// are treated as matching any character.
+	maxClass = 100
+)
+
+// The following limits trade the precision of a query, measured by the
+// number of candidate files, against the number of posting lists it reads.
+// They are variables only so that BenchmarkQueryLimits, which measures
+// both on an index of the Go source tree, can try other values.
+var (
+	// Repetitions x{n,m} with m ≤ maxRepeat are unrolled.
+	// Larger ones are analyzed using min(n, maxRepeat) copies of x.
+	// Three copies are needed for x{3} to yield the trigram xxx.
+	// More unroll [0-9]{1,4} into sets too big to keep, so that
+	// [0-9]{1,4}\.[0-9]{1,4}\. becomes + instead of "0.0"|...|"9.9".
+	maxRepeat = 3
+
+	// Sets of single characters, which come from character classes,
+	// are limited to maxCharSet strings instead of maxSet, so that
+	// [a-z]bc and [A-Za-z]xy turn into ORs of trigrams instead of +.
+	// In the benchmark, 20 leaves both matching every file,
+	// 52 cuts them to under a tenth, and 64, for \w, barely
+	// improves \w+\.go while making its query ten times slower.
+	maxCharSet = 52
 )
 
 // anyMatch returns the regexpInfo describing a regexp that
@@ -487,21 +555,14 @@ func analyze(re *syntax.Regexp) (ret regexpInfo) {
 		return anyMatch()
 
 	case syntax.OpRepeat:
-		if re.Min == 0 {
-			// Like OpStar
-			return anyMatch()
-		}
-		fallthrough
+		return analyzeRepeat(re)
+
 	case syntax.OpPlus:
 		// x+
 		// Since there has to be at least one x, the prefixes and suffixes
 		// stay the same.  If x was exact, it isn't anymore.
 		info = analyze(re.Sub[0])
-		if info.exact.have() {
-			info.prefix = info.exact
-			info.suffix = info.exact.copy()
-			info.exact = nil
-		}
+		info.inexact()
 
 	case syntax.OpCharClass:
 		info.match = allQuery
@@ -519,10 +580,10 @@ func analyze(re *syntax.Regexp) (ret regexpInfo) {
 
 		n := 0
 		for i := 0; i < len(re.Rune); i += 2 {
-			n += int(re.Rune[i+1] - re.Rune[i])
+			n += int(re.Rune[i+1]-re.Rune[i]) + 1
 		}
 		// If the class is too large, it's okay to overestimate.
-		if n > 100 {
+		if n > maxClass {
 			return anyChar()
 		}
 
@@ -539,6 +600,58 @@ func analyze(re *syntax.Regexp) (ret regexpInfo) {
 	return info
 }
 
+// analyzeRepeat returns the regexpInfo for x{n,m}.
+// Small repetitions are unrolled, so that x{2,3} is analyzed as xxx?.
+// Larger ones are analyzed as their first maxRepeat copies,
+// which determine the prefixes, the suffixes, and enough trigrams.
+func analyzeRepeat(re *syntax.Regexp) regexpInfo {
+	lo, hi := re.Min, re.Max
+	if lo == 0 {
+		if hi == 0 {
+			return emptyString()
+		}
+		if hi < 0 {
+			// Like OpStar
+			return anyMatch()
+		}
+		// x{0,m} is (x{1,m})?
+		re1 := *re
+		re1.Min = 1
+		return alternate(analyzeRepeat(&re1), emptyString())
+	}
+
+	x := analyze(re.Sub[0])
+	info := x
+	for i := 1; i < lo && i < maxRepeat; i++ {
+		info = concat(info, x)
+	}
+	if lo > maxRepeat || hi < 0 || hi > maxRepeat {
+		// As with x+, the prefixes and suffixes of the copies
+		// analyzed are the prefixes and suffixes of the whole,
+		// but the exact set is not known.
+		info.inexact()
+		info.simplify(false)
+		return info
+	}
+	if hi > lo {
+		xq := alternate(x, emptyString())
+		for i := lo; i < hi; i++ {
+			info = concat(info, xq)
+		}
+	}
+	return info
+}
+
+// inexact turns an exact set into the equivalent prefix and suffix sets,
+// for use when more text may follow and precede the exact strings.
+func (info *regexpInfo) inexact() {
+	if info.exact.have() {
+		info.prefix = info.exact
+		info.suffix = info.exact.copy()
+		info.exact = nil
+	}
+}
+
 // fold is the usual higher-order function.
 func fold(f func(x, y regexpInfo) regexpInfo, sub []*syntax.Regexp, zero regexpInfo) regexpInfo {
 	if len(sub) == 0 {
@@ -670,7 +783,14 @@ func (info *regexpInfo) simplifySet(s *stringSet) {
 	// Add the OR of the current prefix/suffix set to the query.
 	info.match = info.match.andTrigrams(t)
 
-	for n := 3; n == 3 || t.size() > maxSet; n-- {
+	limit := maxSet
+	if t.maxLen() <= 1 {
+		// A set of single characters, as from [a-z], is kept
+		// up to a larger size, so that [a-z]bc can use the
+		// trigrams abc, bbc, ..., zbc.
+		limit = maxCharSet
+	}
+	for n := 3; n == 3 || t.size() > limit; n-- {
 		// Replace set by strings of length n-1.
 		w := 0
 		for _, str := range t {
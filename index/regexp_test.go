@@ -5,7 +5,15 @@
 package index
 
 import (
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"regexp/syntax"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -78,6 +86,41 @@ var queryTests = []struct {
 	{`abc\B`, `"abc"`},
 	{`ab\bc`, `"abc"`},
 	{`ab\Bc`, `"abc"`},
+
+	// Small bounded repetitions are unrolled.
+	{`x{3}`, `"xxx"`},
+	{`x{6}`, `"xxx"`},
+	{`ab{2,4}c`, `"abb" "bbc"`},
+	{`(ab){2}`, `"aba" "bab"`},
+	{`a{2,}bc`, `"aab" "abc"`},
+	{`a{0,2}bcd`, `"bcd"`},
+	{`a{0}bcd`, `"bcd"`},
+	{`[xy]{2}z`, `("xxz"|"xyz"|"yxz"|"yyz")`},
+	{`(?i)ab{2}`, `("ABB"|"ABb"|"AbB"|"Abb"|"aBB"|"aBb"|"abB"|"abb")`},
+	{`x{2,9}`, `+`},
+
+	// Classes of up to maxCharSet characters are expanded.
+	{`[a-z]bc`, `("abc"|"bbc"|"cbc"|"dbc"|"ebc"|"fbc"|"gbc"|"hbc"|"ibc"|"jbc"|"kbc"|"lbc"|"mbc"|"nbc"|"obc"|"pbc"|"qbc"|"rbc"|"sbc"|"tbc"|"ubc"|"vbc"|"wbc"|"xbc"|"ybc"|"zbc")`},
+	{`\w+xy`, `+`},
+
+	// Real-world patterns.
+	{`[a-z]{3}Handler`, `"Han" "and" "dle" "ler" "ndl"`},
+	{`0x[0-9a-f]{8}`, `("0x0"|"0x1"|"0x2"|"0x3"|"0x4"|"0x5"|"0x6"|"0x7"|"0x8"|"0x9"|"0xa"|"0xb"|"0xc"|"0xd"|"0xe"|"0xf")`},
+	{`[0-9a-f]{40}`, `+`},
+	{`\w(Read|Write)er`, `("Rea" "ead")|("Wri" "ite" "rit") ("ade" "der")|("eer" "tee")`},
+	{`[A-Z][a-z]+Error`, `"Err" "ror" "rro"`},
+	{`TODO\(\w+\)`, `"DO(" "ODO" "TOD"`},
+	{`https?://`, `"://" "htt" "ttp" ("p:/" "tp:")|("ps:" "s:/")`},
+	{`\bint(8|16|32|64)\b`, `"int" ("nt8")|("nt1" "t16")|("nt3" "t32")|("nt6" "t64")`},
+	{`func \(\w+ \*\w+\) Close\(`, `" Cl" ") C" "Clo" "c (" "fun" "los" "nc " "ose" "se(" "unc"`},
+	{`\d{4}-\d{2}`, `("0-0"|"0-1"|"0-2"|"0-3"|"0-4"|"0-5"|"0-6"|"0-7"|"0-8"|"0-9"|"1-0"|"1-1"|"1-2"|"1-3"|"1-4"|"1-5"|"1-6"|"1-7"|"1-8"|"1-9"|"2-0"|"2-1"|"2-2"|"2-3"|"2-4"|"2-5"|"2-6"|"2-7"|"2-8"|"2-9"|"3-0"|"3-1"|"3-2"|"3-3"|"3-4"|"3-5"|"3-6"|"3-7"|"3-8"|"3-9"|"4-0"|"4-1"|"4-2"|"4-3"|"4-4"|"4-5"|"4-6"|"4-7"|"4-8"|"4-9"|"5-0"|"5-1"|"5-2"|"5-3"|"5-4"|"5-5"|"5-6"|"5-7"|"5-8"|"5-9"|"6-0"|"6-1"|"6-2"|"6-3"|"6-4"|"6-5"|"6-6"|"6-7"|"6-8"|"6-9"|"7-0"|"7-1"|"7-2"|"7-3"|"7-4"|"7-5"|"7-6"|"7-7"|"7-8"|"7-9"|"8-0"|"8-1"|"8-2"|"8-3"|"8-4"|"8-5"|"8-6"|"8-7"|"8-8"|"8-9"|"9-0"|"9-1"|"9-2"|"9-3"|"9-4"|"9-5"|"9-6"|"9-7"|"9-8"|"9-9")`},
+	{`[0-9]{1,4}\.[0-9]{1,4}\.`, `("0.0"|"0.1"|"0.2"|"0.3"|"0.4"|"0.5"|"0.6"|"0.7"|"0.8"|"0.9"|"1.0"|"1.1"|"1.2"|"1.3"|"1.4"|"1.5"|"1.6"|"1.7"|"1.8"|"1.9"|"2.0"|"2.1"|"2.2"|"2.3"|"2.4"|"2.5"|"2.6"|"2.7"|"2.8"|"2.9"|"3.0"|"3.1"|"3.2"|"3.3"|"3.4"|"3.5"|"3.6"|"3.7"|"3.8"|"3.9"|"4.0"|"4.1"|"4.2"|"4.3"|"4.4"|"4.5"|"4.6"|"4.7"|"4.8"|"4.9"|"5.0"|"5.1"|"5.2"|"5.3"|"5.4"|"5.5"|"5.6"|"5.7"|"5.8"|"5.9"|"6.0"|"6.1"|"6.2"|"6.3"|"6.4"|"6.5"|"6.6"|"6.7"|"6.8"|"6.9"|"7.0"|"7.1"|"7.2"|"7.3"|"7.4"|"7.5"|"7.6"|"7.7"|"7.8"|"7.9"|"8.0"|"8.1"|"8.2"|"8.3"|"8.4"|"8.5"|"8.6"|"8.7"|"8.8"|"8.9"|"9.0"|"9.1"|"9.2"|"9.3"|"9.4"|"9.5"|"9.6"|"9.7"|"9.8"|"9.9")`},
+
+	// Multiline patterns use the trigrams spanning newlines.
+	{`foo\nbar`, `"\nba" "bar" "foo" "o\nb" "oo\n"`},
+	{`(?s)abc.def`, `"abc" "def"`},
+	{`abc\n\s*def`, `"abc" "bc\n" "def"`},
+	{`\}\n\}`, `"}\n}"`},
 }
 
 func TestQuery(t *testing.T) {
@@ -92,3 +135,136 @@ func TestQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestQuerySize(t *testing.T) {
+	for _, tt := range []struct {
+		re   string
+		size int
+	}{
+		{`Abcdef`, 4},
+		{`abc.*(def|ghi)`, 3},
+		{`\w+xy`, 0},
+		{`\w(Read|Write)er`, 9},
+	} {
+		re, err := syntax.Parse(tt.re, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := RegexpQuery(re).Size(); size != tt.size {
+			t.Errorf("RegexpQuery(%#q).Size() = %d, want %d", tt.re, size, tt.size)
+		}
+	}
+}
+
+func TestFuzzyTrigrams(t *testing.T) {
+	tests := []struct {
+		lit  string
+		k    int
+		tri  string
+		need int
+	}{
+		{"Receive", 1, `["Rec" "cei" "ece" "eiv" "ive"]`, 2},
+		{"aaaaaa", 1, `["aaa"]`, -2},
+		{"ErrNotExist", 2, `["Err" "Exi" "Not" "ist" "otE" "rNo" "rrN" "tEx" "xis"]`, 3},
+		{"naïve", 1, `["aï" "na\xc3" "\xafve" "ïv"]`, 0},
+	}
+	for _, tt := range tests {
+		tri, need := FuzzyTrigrams(tt.lit, tt.k)
+		if s := fmt.Sprintf("%q", tri); s != tt.tri || need != tt.need {
+			t.Errorf("FuzzyTrigrams(%q, %d) = %s, %d, want %s, %d", tt.lit, tt.k, s, need, tt.tri, tt.need)
+		}
+	}
+
+	// Check the bound on random edits.
+	r := rand.New(rand.NewSource(1))
+	const alpha = "abcdé"
+	for range 10000 {
+		lit := []rune{}
+		for range 3 + r.Intn(8) {
+			lit = append(lit, []rune(alpha)[r.Intn(len(alpha)-1)])
+		}
+		k := 1 + r.Intn(2)
+		text := slices.Clone(lit)
+		for range k {
+			i := r.Intn(len(text) + 1)
+			c := []rune(alpha)[r.Intn(len([]rune(alpha)))]
+			switch op := r.Intn(3); {
+			case op == 0:
+				text = slices.Insert(text, i, c)
+			case i < len(text) && op == 1:
+				text = slices.Delete(text, i, i+1)
+			case i < len(text):
+				text[i] = c
+			}
+		}
+		tri, need := FuzzyTrigrams(string(lit), k)
+		have := 0
+		for _, t := range tri {
+			if strings.Contains(string(text), t) {
+				have++
+			}
+		}
+		if have < need {
+			t.Fatalf("FuzzyTrigrams(%q, %d) requires %d trigrams, but %q has %d", string(lit), k, need, string(text), have)
+		}
+	}
+}
+
+// BenchmarkQueryLimits measures, for patterns that depend on maxCharSet
+// and maxRepeat, the number of candidate files ("files") and the time to
+// compute them with other values of those limits, on an index of the
+// .go files in $GOROOT/src. Run it to check the values chosen:
+//
+//	go test -run=NONE -bench=QueryLimits ./index
+func BenchmarkQueryLimits(b *testing.B) {
+	src := filepath.Join(runtime.GOROOT(), "src")
+	if _, err := os.Stat(src); err != nil {
+		b.Skip(err)
+	}
+	file := filepath.Join(b.TempDir(), "index")
+	w := Create(file)
+	filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".go") {
+			w.AddFile(path)
+		}
+		return nil
+	})
+	w.Flush()
+	ix := Open(file)
+	defer ix.Close()
+	b.Logf("indexed %d files", ix.numName)
+
+	for _, tt := range []struct {
+		limit  *int
+		name   string
+		values []int
+		re     string
+	}{
+		{&maxCharSet, "maxCharSet", []int{20, 52, 64}, `[a-z]bc`},
+		{&maxCharSet, "maxCharSet", []int{20, 52, 64}, `[A-Za-z]xy`},
+		{&maxCharSet, "maxCharSet", []int{20, 52, 64}, `\w+\.go`},
+		{&maxRepeat, "maxRepeat", []int{2, 3, 4, 8}, `\d{4}-\d{2}`},
+		{&maxRepeat, "maxRepeat", []int{2, 3, 4, 8}, `[a-z]{3}Handler`},
+		{&maxRepeat, "maxRepeat", []int{2, 3, 4, 8}, `(ab){6}`},
+		{&maxRepeat, "maxRepeat", []int{2, 3, 4, 8}, `(?i)x{2,4}y`},
+		{&maxRepeat, "maxRepeat", []int{2, 3, 4, 8}, `0x[0-9a-f]{8}`},
+		{&maxRepeat, "maxRepeat", []int{2, 3, 4, 8}, `[0-9]{1,4}\.[0-9]{1,4}\.`},
+	} {
+		re, err := syntax.Parse(tt.re, syntax.Perl)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, v := range tt.values {
+			b.Run(fmt.Sprintf("%s/%s=%d", tt.re, tt.name, v), func(b *testing.B) {
+				old := *tt.limit
+				*tt.limit = v
+				defer func() { *tt.limit = old }()
+				var n int
+				for range b.N {
+					n = len(ix.PostingQuery(RegexpQuery(re)))
+				}
+				b.ReportMetric(float64(n), "files")
+			})
+		}
+	}
+}
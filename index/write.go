@@ -16,6 +16,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/google/codesearch/charset"
 	"github.com/google/codesearch/sparse"
 )
 
@@ -41,6 +42,10 @@ type IndexWriter struct {
 	Verbose bool // log status using package log
 	Zip     bool // index content of zip files
 
+	// Encodings gives the encodings of files without byte order marks.
+	// Files in other encodings are converted to UTF-8 for indexing.
+	Encodings *charset.Map
+
 	trigram *sparse.Set // trigrams for the current file
 	buf     [32]byte    // scratch buffer
 
@@ -125,7 +130,8 @@ func makePostEntry(trigram uint32, fileid int) postEntry {
 
 // Tuning constants for detecting text files.
 // A file is assumed not to be text files (and thus not indexed)
-// if it contains an invalid UTF-8 sequences, if it is longer than maxFileLength
+// if, after conversion to UTF-8 (see IndexWriter.Encodings),
+// it contains an invalid UTF-8 sequences, if it is longer than maxFileLength
 // bytes, if it contains a line longer than maxLineLen bytes,
 // or if it contains more than maxTextTrigrams distinct trigrams.
 const (
@@ -214,6 +220,7 @@ NoZip:
 
 func (ix *IndexWriter) add(name string, f io.Reader) error {
 	ix.trigram.Reset()
+	f = charset.NewReader(f, ix.Encodings.Lookup(name))
 	var (
 		c       = byte(0)
 		i       = 0
@@ -305,6 +312,9 @@ func (ix *IndexWriter) Flush() {
 		ix.main.WriteString(magicV1)
 	} else {
 		ix.main.WriteString(magicV2)
+		for _, rule := range ix.Encodings.Rules() {
+			ix.main.WriteString(rule + "\n")
+		}
 	}
 
 	// Path list.
@@ -9,8 +9,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -410,3 +414,64 @@ func TestZip(t *testing.T) {
 	checkPosting(t, ix, "now", 3, 4, 6)
 	checkPosting(t, ix, "pot", 4, 5, 7)
 }
+
+func TestOpener(t *testing.T) {
+	dir := t.TempDir()
+	writeZip := func(name string, files ...string) string {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for i := 0; i < len(files); i += 2 {
+			ww, err := w.Create(files[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			ww.Write([]byte(files[i+1]))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	x := writeZip("x.zip", "a/x", "hello", "b", "world")
+	y := writeZip("y.zip", "c", "potatoes")
+	plain := filepath.Join(dir, "plain")
+	if err := os.WriteFile(plain, []byte("text"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var o Opener
+	defer o.Close()
+	for _, tt := range []struct {
+		name string
+		data string // "" for a missing file
+	}{
+		{plain, "text"},
+		{x + "\x01a/x", "hello"},
+		{x + "\x01b", "world"},
+		{y + "\x01c", "potatoes"},
+		{x + "\x01a/x", "hello"},
+		{x + "\x01c", ""},
+		{filepath.Join(dir, "missing"), ""},
+	} {
+		r, err := o.Open(tt.name)
+		if tt.data == "" {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Open(%q): %v, want not exist error", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Open(%q): %v", tt.name, err)
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != tt.data {
+			t.Errorf("Open(%q): read %q, %v, want %q", tt.name, data, err, tt.data)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"iter"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/regexp"
+)
+
+// Grep searches the named files, printing the results with g,
+// whose Regexp is normally s.Regexp or a multiline form of it.
+//
+// If s is not nil, Grep skips the files that s.MatchName rules out,
+// and if s.Verify is set, it reads each remaining file in full and
+// searches it only if its text, converted to UTF-8 as g.Encodings says,
+// matches s. A file that matches s by name
+// alone is listed with g.List, as is every file if g.Regexp is nil.
+//
+// Grep stops early when ctx is done, when g has read g.MaxBytes bytes,
+// or when g reaches its limit, recording why in g.Incomplete or g.Limited.
+// Files that cannot be opened are counted in g.Missing.
+func Grep(ctx context.Context, g *regexp.Grep, s *Search, names iter.Seq[string]) {
+	var o index.Opener
+	defer o.Close()
+	for name := range names {
+		if err := ctx.Err(); err != nil {
+			g.Incomplete = err
+			return
+		}
+		if g.MaxBytes > 0 && g.Bytes >= g.MaxBytes {
+			g.Incomplete = regexp.ErrMaxBytes
+			return
+		}
+		if g.Limited {
+			return
+		}
+		if s != nil && !s.MatchName(name) {
+			continue
+		}
+		if g.Regexp == nil {
+			// No content to match: list the files that match by name.
+			g.List(name)
+			continue
+		}
+		f, err := o.Open(name)
+		if err != nil {
+			g.Missing++
+			continue
+		}
+		if s == nil || !s.Verify {
+			err = g.ReaderContext(ctx, f, name)
+			f.Close()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		// Match the text as g will search it, converted to UTF-8.
+		text, err := io.ReadAll(charset.NewReader(bytes.NewReader(data), g.Encodings.Lookup(name)))
+		if err != nil {
+			continue
+		}
+		if !s.Match(name, text) {
+			g.Files++
+			g.Bytes += int64(len(text))
+			continue
+		}
+		n := g.Matches
+		if g.ReaderContext(ctx, bytes.NewReader(data), name) != nil {
+			return
+		}
+		if g.Matches == n {
+			// The file matched by name alone.
+			g.List(name)
+		}
+	}
+}
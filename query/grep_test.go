@@ -0,0 +1,149 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/codesearch/charset"
+	"github.com/google/codesearch/regexp"
+)
+
+var grepFiles = []struct {
+	name string
+	data string
+}{
+	{"a.go", "package a\n\nfunc F() {}\n"},
+	{"b.txt", "no code here\n"},
+	{"c.go", "package c\n"},
+}
+
+// writeGrepFiles writes grepFiles to a temporary directory
+// and returns the directory and the names of the files in it.
+func writeGrepFiles(t *testing.T) (string, []string) {
+	dir := t.TempDir()
+	var names []string
+	for _, f := range grepFiles {
+		name := filepath.Join(dir, f.name)
+		if err := os.WriteFile(name, []byte(f.data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	// A file that has gone missing since it was indexed.
+	names = append(names, filepath.Join(dir, "d.go"))
+	return dir, names
+}
+
+func TestGrep(t *testing.T) {
+	dir, names := writeGrepFiles(t)
+	for _, tt := range []struct {
+		q   string // query, or regexp if it begins with (?m)
+		out string
+	}{
+		{`(?m)package`, "a.go:package a\nc.go:package c\n"},
+		{`func`, "a.go:func F() {}\n"},
+		{`file:\.go$`, "a.go\nc.go\nd.go\n"},
+		{`func or file:\.txt$`, "a.go:func F() {}\nb.txt\n"},
+		{`package -file:^.*a\.go$`, "c.go:package c\n"},
+		{`package code`, ""},
+	} {
+		var s *Search
+		var re *regexp.Regexp
+		var err error
+		if strings.HasPrefix(tt.q, "(?m)") {
+			re, err = regexp.Compile(tt.q)
+		} else {
+			var e *Expr
+			e, err = Parse(tt.q, CaseAuto)
+			if err == nil {
+				s, err = Compile(e)
+			}
+			if s != nil {
+				re = s.Regexp
+			}
+		}
+		if err != nil {
+			t.Errorf("%#q: %v", tt.q, err)
+			continue
+		}
+		var out bytes.Buffer
+		g := &regexp.Grep{Regexp: re, Stdout: &out, Stderr: &out}
+		Grep(context.Background(), g, s, slices.Values(names))
+		if got := strings.ReplaceAll(out.String(), dir+string(filepath.Separator), ""); got != tt.out {
+			t.Errorf("%#q: output %q, want %q", tt.q, got, tt.out)
+		}
+	}
+}
+
+func TestGrepStats(t *testing.T) {
+	dir, names := writeGrepFiles(t)
+	e, err := Parse(`func or file:\.txt$`, CaseAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Compile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, errb bytes.Buffer
+	g := &regexp.Grep{Regexp: s.Regexp, Stdout: &out, Stderr: &errb, C: true, Stats: regexp.NewStats()}
+	Grep(context.Background(), g, s, slices.Values(names))
+	g.Finish()
+	if got, want := strings.ReplaceAll(out.String(), dir+string(filepath.Separator), ""), "a.go: 1\nb.txt: 1\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+	st := g.Stats
+	if !g.Match || st.Files != 3 || st.Missing != 1 || st.FilesMatched != 2 || st.Matches != 2 {
+		t.Errorf("Match = %v, stats %d files read, %d missing, %d files matched, %d matches; want true, 3, 1, 2, 2",
+			g.Match, st.Files, st.Missing, st.FilesMatched, st.Matches)
+	}
+}
+
+func TestGrepEncodings(t *testing.T) {
+	dir := t.TempDir()
+	files := []struct {
+		name string
+		data string
+	}{
+		// UTF-16LE with a byte order mark: "hello foo\nbar\n".
+		{"a.txt", "\xff\xfeh\x00e\x00l\x00l\x00o\x00 \x00f\x00o\x00o\x00\n\x00b\x00a\x00r\x00\n\x00"},
+		// Latin-1, named by a rule: "café foo\nbar\n".
+		{"b.l1", "caf\xe9 foo\nbar\n"},
+	}
+	var names []string
+	for _, f := range files {
+		name := filepath.Join(dir, f.name)
+		if err := os.WriteFile(name, []byte(f.data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	e, err := Parse(`foo bar`, CaseAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Compile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encodings charset.Map
+	if err := encodings.Set("*.l1=latin1"); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	g := &regexp.Grep{Regexp: s.Regexp, Stdout: &out, Stderr: &out, Encodings: &encodings}
+	Grep(context.Background(), g, s, slices.Values(names))
+	want := "a.txt:hello foo\na.txt:bar\nb.l1:café foo\nb.l1:bar\n"
+	if got := strings.ReplaceAll(out.String(), dir+string(filepath.Separator), ""); got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+}
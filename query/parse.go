@@ -0,0 +1,364 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package query implements a small query language for code search,
+// in the style of Zoekt.
+//
+// A query is a sequence of terms separated by spaces.
+// A file matches the query if it matches all the terms.
+// The terms are:
+//
+//	word         regular expression to search for in file contents
+//	"text"       literal text to search for in file contents
+//	file:word    file name must match regular expression (or "literal")
+//	lang:name    file must be written in the named language
+//	root:path    file must be in the directory tree rooted at path
+//	case:yes     content and file name matches are case-sensitive
+//	case:no      content and file name matches are case-insensitive
+//	case:auto    case-sensitive only if the pattern has upper case letters
+//
+// A file:, lang:, or root: term preceded by a minus sign is negated:
+// -file:_test\.go$ excludes test files.
+// Terms can be grouped with parentheses, and two terms
+// (or groups) separated by the word "or" match if either does.
+// Juxtaposition binds more tightly than "or", so
+//
+//	foo bar or baz
+//
+// means (foo bar) or baz.
+//
+// Parentheses inside a bare word are part of the word as long as
+// they are balanced, so that regular expressions like f(x|y) need no quoting.
+// A group is not joined to a word that follows it: (a or b)c means
+// (a or b) c, a file matching a or b and also c, perhaps on another line.
+// Inside double quotes, \" and \\ stand for " and \.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An Expr is a parsed query expression.
+type Expr struct {
+	Op   Op
+	Arg  string  // pattern, language, or path for atoms
+	Not  bool    // atom is negated
+	Case Case    // case sensitivity for OpContent and OpFile
+	Sub  []*Expr // subexpressions for OpAnd and OpOr
+}
+
+type Op int
+
+const (
+	OpAnd     Op = iota // All in Sub must match
+	OpOr                // At least one in Sub must match
+	OpContent           // File content must match regexp Arg
+	OpFile              // File name must match regexp Arg
+	OpLang              // File must be in language Arg
+	OpRoot              // File must be in directory tree Arg
+)
+
+// A Case specifies the case sensitivity of a pattern.
+type Case int
+
+const (
+	CaseAuto        Case = iota // case-sensitive if pattern has upper case
+	CaseSensitive               // case:yes
+	CaseInsensitive             // case:no
+)
+
+var opNames = []string{
+	OpAnd:     "and",
+	OpOr:      "or",
+	OpContent: "content",
+	OpFile:    "file",
+	OpLang:    "lang",
+	OpRoot:    "root",
+}
+
+var caseNames = []string{
+	CaseAuto:        "auto",
+	CaseSensitive:   "yes",
+	CaseInsensitive: "no",
+}
+
+func (e *Expr) String() string {
+	switch e.Op {
+	case OpAnd, OpOr:
+		s := "(" + opNames[e.Op]
+		for _, sub := range e.Sub {
+			s += " " + sub.String()
+		}
+		return s + ")"
+	}
+	s := ""
+	if e.Not {
+		s = "-"
+	}
+	s += opNames[e.Op] + ":" + strconv.Quote(e.Arg)
+	if (e.Op == OpContent || e.Op == OpFile) && e.Case != CaseAuto {
+		s += "/case:" + caseNames[e.Case]
+	}
+	return s
+}
+
+// Parse parses the query s.
+// Content and file name atoms use case sensitivity c
+// unless the query contains a case: term.
+func Parse(s string, c Case) (*Expr, error) {
+	p := &parser{s: s, c: c}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.s != "" {
+		// Only an unmatched ) can stop the parse early.
+		return nil, fmt.Errorf("unexpected )")
+	}
+	if e == nil {
+		return nil, fmt.Errorf("empty query")
+	}
+	p.setCase(e)
+	return e, nil
+}
+
+// maxDepth limits the nesting of parentheses in a query,
+// so that parsing and compiling it cannot overflow the stack.
+const maxDepth = 100
+
+type parser struct {
+	s     string // remaining input
+	c     Case   // case sensitivity
+	depth int    // parenthesis nesting
+}
+
+// setCase applies p.c to every content and file name atom in e.
+func (p *parser) setCase(e *Expr) {
+	switch e.Op {
+	case OpAnd, OpOr:
+		for _, sub := range e.Sub {
+			p.setCase(sub)
+		}
+	case OpContent, OpFile:
+		e.Case = p.c
+	}
+}
+
+func (p *parser) skipSpace() {
+	p.s = strings.TrimLeft(p.s, " \t\r\n")
+}
+
+// or parses a sequence of and-expressions separated by "or".
+// It returns a nil Expr for an empty sequence.
+func (p *parser) or() (*Expr, error) {
+	var list []*Expr
+	for {
+		e, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.isOr() {
+			if e == nil {
+				if len(list) > 0 {
+					return nil, fmt.Errorf("missing term after or")
+				}
+				return nil, nil
+			}
+			list = append(list, e)
+			break
+		}
+		if e == nil {
+			return nil, fmt.Errorf("missing term before or")
+		}
+		list = append(list, e)
+		p.s = p.s[len("or"):]
+	}
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	return &Expr{Op: OpOr, Sub: list}, nil
+}
+
+// isOr reports whether the input begins with the word "or".
+func (p *parser) isOr() bool {
+	if !strings.HasPrefix(p.s, "or") {
+		return false
+	}
+	rest := p.s[len("or"):]
+	return rest == "" || strings.ContainsAny(rest[:1], " \t\r\n()")
+}
+
+// and parses a sequence of terms, stopping at "or", ")" or end of input.
+// It returns a nil Expr for an empty sequence.
+func (p *parser) and() (*Expr, error) {
+	var list []*Expr
+	for {
+		p.skipSpace()
+		if p.s == "" || p.s[0] == ')' || p.isOr() {
+			break
+		}
+		e, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			list = append(list, e)
+		}
+	}
+	switch len(list) {
+	case 0:
+		return nil, nil
+	case 1:
+		return list[0], nil
+	}
+	return &Expr{Op: OpAnd, Sub: list}, nil
+}
+
+// term parses a single term: a parenthesized group or an atom.
+// It returns a nil Expr for terms that only set options, like case:yes.
+func (p *parser) term() (*Expr, error) {
+	if p.s[0] == '(' {
+		p.s = p.s[1:]
+		p.depth++
+		if p.depth > maxDepth {
+			return nil, fmt.Errorf("parentheses nested more than %d deep", maxDepth)
+		}
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.s == "" || p.s[0] != ')' {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.s = p.s[1:]
+		p.depth--
+		if e == nil {
+			return nil, fmt.Errorf("empty parentheses")
+		}
+		return e, nil
+	}
+
+	not := false
+	if strings.HasPrefix(p.s, "-") {
+		not = true
+		p.s = p.s[1:]
+	}
+	op := OpContent
+	for _, f := range []Op{OpFile, OpLang, OpRoot} {
+		if strings.HasPrefix(p.s, opNames[f]+":") {
+			op = f
+			p.s = p.s[len(opNames[f])+1:]
+			break
+		}
+	}
+	if op == OpContent && strings.HasPrefix(p.s, "case:") {
+		if not {
+			return nil, fmt.Errorf("cannot negate case:")
+		}
+		p.s = p.s[len("case:"):]
+		arg, _, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		switch arg {
+		case "yes":
+			p.c = CaseSensitive
+		case "no":
+			p.c = CaseInsensitive
+		case "auto":
+			p.c = CaseAuto
+		default:
+			return nil, fmt.Errorf("invalid case:%s (want yes, no, or auto)", arg)
+		}
+		return nil, nil
+	}
+	if not && op == OpContent {
+		return nil, fmt.Errorf("cannot negate content search (quote a leading - to search for it)")
+	}
+
+	arg, quoted, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+	if arg == "" && !quoted {
+		return nil, fmt.Errorf("missing argument for %s:", opNames[op])
+	}
+	if quoted && (op == OpContent || op == OpFile) {
+		arg = quoteMeta(arg)
+	}
+	return &Expr{Op: op, Arg: arg, Not: not}, nil
+}
+
+// word parses a bare or double-quoted word.
+func (p *parser) word() (w string, quoted bool, err error) {
+	if strings.HasPrefix(p.s, `"`) {
+		var b strings.Builder
+		for i := 1; i < len(p.s); i++ {
+			switch c := p.s[i]; c {
+			case '"':
+				p.s = p.s[i+1:]
+				return b.String(), true, nil
+			case '\\':
+				if i+1 < len(p.s) && (p.s[i+1] == '"' || p.s[i+1] == '\\') {
+					i++
+					c = p.s[i]
+				}
+				b.WriteByte(c)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", false, fmt.Errorf("missing closing quote")
+	}
+
+	// A bare word ends at a space or at a ) that closes an enclosing group.
+	depth := 0
+	class := false
+	i := 0
+Loop:
+	for ; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '\\':
+			i++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '(':
+			if !class {
+				depth++
+			}
+		case ')':
+			if !class {
+				if depth == 0 {
+					break Loop
+				}
+				depth--
+			}
+		case ' ', '\t', '\r', '\n':
+			if depth <= 0 && !class {
+				break Loop
+			}
+		}
+	}
+	i = min(i, len(p.s))
+	w, p.s = p.s[:i], p.s[i:]
+	return w, false, nil
+}
+
+// quoteMeta returns a regular expression matching the literal text s.
+func quoteMeta(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`\.+*?()|[]{}^$`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
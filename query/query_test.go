@@ -0,0 +1,229 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var parseTests = []struct {
+	q   string
+	out string
+}{
+	{`foo`, `content:"foo"`},
+	{`foo bar`, `(and content:"foo" content:"bar")`},
+	{`foo or bar`, `(or content:"foo" content:"bar")`},
+	{`foo bar or baz`, `(or (and content:"foo" content:"bar") content:"baz")`},
+	{`foo (bar or baz)`, `(and content:"foo" (or content:"bar" content:"baz"))`},
+	{`"a b(c"`, `content:"a b\\(c"`},
+	{`"say \"hi\""`, `content:"say \"hi\""`},
+	{`f(x|y) z`, `(and content:"f(x|y)" content:"z")`},
+	{`(f(x) or [)])`, `(or content:"f(x)" content:"[)]")`},
+	{`(Marshal or Unmarshal)JSON`, `(and (or content:"Marshal" content:"Unmarshal") content:"JSON")`},
+	{`(MarshalJSON or UnmarshalJSON)`, `(or content:"MarshalJSON" content:"UnmarshalJSON")`},
+	{`orange or`, `error: missing term after or`},
+	{`oracle`, `content:"oracle"`},
+	{`file:\.go$ -file:_test\.go$`, `(and file:"\\.go$" -file:"_test\\.go$")`},
+	{`file:"a.b"`, `file:"a\\.b"`},
+	{`lang:go root:/src foo`, `(and lang:"go" root:"/src" content:"foo")`},
+	{`case:yes Foo file:x`, `(and content:"Foo"/case:yes file:"x"/case:yes)`},
+	{`foo case:no`, `content:"foo"/case:no`},
+	{`case:maybe foo`, `error: invalid case:maybe (want yes, no, or auto)`},
+	{`-foo`, `error: cannot negate content search (quote a leading - to search for it)`},
+	{`"-foo"`, `content:"-foo"`},
+	{`(foo`, `error: missing )`},
+	{`foo)`, `error: unexpected )`},
+	{`()`, `error: empty parentheses`},
+	{`"foo`, `error: missing closing quote`},
+	{``, `error: empty query`},
+	{`file:`, `error: missing argument for file:`},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		e, err := Parse(tt.q, CaseAuto)
+		var out string
+		if err != nil {
+			out = "error: " + err.Error()
+		} else {
+			out = e.String()
+		}
+		if out != tt.out {
+			t.Errorf("Parse(%#q) = %#q, want %#q", tt.q, out, tt.out)
+		}
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	q := strings.Repeat("(", maxDepth) + "foo" + strings.Repeat(")", maxDepth)
+	if _, err := Parse(q, CaseAuto); err != nil {
+		t.Errorf("Parse(%d nested parentheses): %v", maxDepth, err)
+	}
+	q = strings.Repeat("(", 1e6) + "foo" + strings.Repeat(")", 1e6)
+	want := fmt.Sprintf("parentheses nested more than %d deep", maxDepth)
+	if _, err := Parse(q, CaseAuto); err == nil || err.Error() != want {
+		t.Errorf("Parse(1e6 nested parentheses) = %v, want %q", err, want)
+	}
+}
+
+var searchTests = []struct {
+	q      string
+	query  string
+	regexp string
+	verify bool
+	names  map[string]bool
+	roots  string
+}{
+	{
+		q:      `abc`,
+		query:  `("ABC"|"ABc"|"AbC"|"Abc"|"aBC"|"aBc"|"abC"|"abc")`,
+		regexp: `(?m)(?:(?i)abc)`,
+		names:  map[string]bool{"x.go": true},
+	},
+	{
+		q:      `Abc file:\.go$ -file:_test`,
+		query:  `"Abc"`,
+		regexp: `(?m)(?:Abc)`,
+		names:  map[string]bool{"x.go": true, "x_test.go": false, "x.c": false},
+	},
+	{
+		q:      `case:yes abc or def lang:c`,
+		query:  `("abc"|"def")`,
+		regexp: `(?m)(?:abc)|(?:def)`,
+		verify: true,
+		names:  map[string]bool{"x.go": true, "x.h": true},
+	},
+	{
+		q:      `case:yes (abc or def) lang:c`,
+		query:  `("abc"|"def")`,
+		regexp: `(?m)(?:abc)|(?:def)`,
+		names:  map[string]bool{"x.go": false, "x.h": true, "/z/y.zip\x01x.c": true},
+	},
+	{
+		q:      `case:yes abc def`,
+		query:  `"abc" "def"`,
+		regexp: `(?m)(?:abc)|(?:def)`,
+		verify: true,
+	},
+	{
+		q:     `-lang:go root:/a`,
+		query: `+`,
+		names: map[string]bool{"/a/x.go": false, "/a/x.c": true, "/ab/x.c": false},
+		roots: `[/a]`,
+	},
+	{
+		q:      `case:yes xyz (root:/a or root:/b file:y) -root:/c`,
+		query:  `"xyz"`,
+		regexp: `(?m)(?:xyz)`,
+		names:  map[string]bool{"/a/x": true, "/b/x": false, "/b/y": true, "/c/y": false},
+		roots:  `[/a /b]`,
+	},
+	{
+		q:      `case:yes xyz (root:/a or file:y)`,
+		query:  `"xyz"`,
+		regexp: `(?m)(?:xyz)`,
+		roots:  `[]`,
+	},
+}
+
+func TestSearch(t *testing.T) {
+	for _, tt := range searchTests {
+		e, err := Parse(tt.q, CaseAuto)
+		if err != nil {
+			t.Errorf("Parse(%#q): %v", tt.q, err)
+			continue
+		}
+		s, err := Compile(e)
+		if err != nil {
+			t.Errorf("Compile(%#q): %v", tt.q, err)
+			continue
+		}
+		if q := s.Query.String(); q != tt.query {
+			t.Errorf("Compile(%#q).Query = %#q, want %#q", tt.q, q, tt.query)
+		}
+		re := ""
+		if s.Regexp != nil {
+			re = s.Regexp.String()
+		}
+		if re != tt.regexp {
+			t.Errorf("Compile(%#q).Regexp = %#q, want %#q", tt.q, re, tt.regexp)
+		}
+		if s.Verify != tt.verify {
+			t.Errorf("Compile(%#q).Verify = %v, want %v", tt.q, s.Verify, tt.verify)
+		}
+		if roots := fmt.Sprint(s.Roots()); tt.roots != "" && roots != tt.roots {
+			t.Errorf("Compile(%#q).Roots() = %s, want %s", tt.q, roots, tt.roots)
+		}
+		for name, want := range tt.names {
+			if m := s.MatchName(name); m != want {
+				t.Errorf("Compile(%#q).MatchName(%q) = %v, want %v", tt.q, name, m, want)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	e, err := Parse(`(abc def) or file:\.txt$`, CaseAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Compile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"x.go", "abc\ndef\n", true},
+		{"x.go", "abc\n", false},
+		{"x.txt", "abc\n", true},
+		{"x.go", "xdefx abcx\n", true},
+	}
+	for _, tt := range tests {
+		if m := s.Match(tt.name, []byte(tt.data)); m != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.name, tt.data, m, tt.want)
+		}
+	}
+}
+
+func TestMatchMultiline(t *testing.T) {
+	e, err := Parse(`func\s\w+\(\)\s*\{\n\} baz`, CaseAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := CompileMultiline(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Regexp.Multiline() {
+		t.Errorf("Regexp %#q is not multiline", s.Regexp)
+	}
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"func F() {\n}\n\nvar baz int\n", true},
+		{"func F() {\n}\n", false},
+		{"func F() { return }\nvar baz int\n", false},
+	}
+	for _, tt := range tests {
+		if m := s.Match("a.go", []byte(tt.data)); m != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.data, m, tt.want)
+		}
+	}
+
+	// Without multiline, the first term cannot match across lines.
+	s, err = Compile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Match("a.go", []byte(tests[0].data)) {
+		t.Errorf("Compile: Match(%q) = true, want false", tests[0].data)
+	}
+}
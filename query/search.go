@@ -0,0 +1,452 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/regexp"
+)
+
+// A Search is a compiled query.
+type Search struct {
+	Expr *Expr
+
+	// Query is a trigram query satisfied by every matching file.
+	Query *index.Query
+
+	// Regexp matches the lines to report in a matching file:
+	// the lines matching any content atom.
+	// It is nil if the query has no content atoms,
+	// in which case matching files should be listed by name.
+	Regexp *regexp.Regexp
+
+	// Regexps holds the compiled content atoms,
+	// in the order they appear in Expr.
+	Regexps []*regexp.Regexp
+
+	// Verify reports whether a file containing a line matched by Regexp
+	// can still fail to match the query, as in "foo bar" or "foo or file:x".
+	// If so, Match must be called with the file content to decide.
+	Verify bool
+
+	n     *node
+	multi bool // compile content atoms with regexp.CompileMultiline
+}
+
+// A node is a compiled Expr.
+type node struct {
+	op   Op
+	not  bool
+	re   *regexp.Regexp // OpContent, OpFile
+	lang *language      // OpLang
+	root index.Path     // OpRoot
+	sub  []*node
+}
+
+// Compile compiles the parsed query e.
+// Relative root: paths are interpreted relative to the current directory.
+func Compile(e *Expr) (*Search, error) {
+	return compile(e, false)
+}
+
+// CompileMultiline is like Compile, but the content atoms, including
+// those Match uses to verify a file, are compiled with
+// regexp.CompileMultiline, so that they can match text spanning lines.
+func CompileMultiline(e *Expr) (*Search, error) {
+	return compile(e, true)
+}
+
+func compile(e *Expr, multi bool) (*Search, error) {
+	s := &Search{Expr: e, multi: multi}
+	n, err := s.compile(e)
+	if err != nil {
+		return nil, err
+	}
+	s.n = n
+	s.Query = n.query()
+	s.Verify = !n.simple()
+
+	var pats []string
+	for _, re := range s.Regexps {
+		// Strip the (?m) added by compile; keep any (?i).
+		pats = append(pats, "(?:"+strings.TrimPrefix(re.String(), "(?m)")+")")
+	}
+	if len(pats) > 0 {
+		s.Regexp, err = s.compileRegexp("(?m)" + strings.Join(pats, "|"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Search) compile(e *Expr) (*node, error) {
+	n := &node{op: e.Op, not: e.Not}
+	switch e.Op {
+	default:
+		return nil, fmt.Errorf("invalid query op %d", e.Op)
+
+	case OpAnd, OpOr:
+		for _, sub := range e.Sub {
+			ns, err := s.compile(sub)
+			if err != nil {
+				return nil, err
+			}
+			n.sub = append(n.sub, ns)
+		}
+
+	case OpContent, OpFile:
+		pat, err := casePattern(e.Arg, e.Case)
+		if err != nil {
+			return nil, err
+		}
+		compile := regexp.Compile
+		if e.Op == OpContent {
+			compile = s.compileRegexp
+		}
+		re, err := compile(pat)
+		if err != nil {
+			return nil, err
+		}
+		n.re = re
+		if e.Op == OpContent {
+			s.Regexps = append(s.Regexps, re)
+		}
+
+	case OpLang:
+		n.lang = languages[strings.ToLower(e.Arg)]
+		if n.lang == nil {
+			return nil, fmt.Errorf("unknown language %q", e.Arg)
+		}
+
+	case OpRoot:
+		root, err := filepath.Abs(e.Arg)
+		if err != nil {
+			return nil, err
+		}
+		n.root = index.MakePath(root)
+	}
+	return n, nil
+}
+
+// casePattern returns the regexp pat with case sensitivity c,
+// ready to compile.
+func casePattern(pat string, c Case) (string, error) {
+	if c == CaseAuto {
+		re, err := syntax.Parse(pat, syntax.Perl)
+		if err != nil {
+			return "", err
+		}
+		c = CaseInsensitive
+		if hasUpper(re) {
+			c = CaseSensitive
+		}
+	}
+	if c == CaseInsensitive {
+		pat = "(?i)" + pat
+	}
+	return "(?m)" + pat, nil
+}
+
+// compileRegexp compiles the content regexp expr.
+func (s *Search) compileRegexp(expr string) (*regexp.Regexp, error) {
+	if s.multi {
+		return regexp.CompileMultiline(expr)
+	}
+	return regexp.Compile(expr)
+}
+
+// hasUpper reports whether any literal in re contains an upper case letter.
+func hasUpper(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral {
+		for _, r := range re.Rune {
+			if unicode.IsUpper(r) {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if hasUpper(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// query returns the trigram query for n.
+func (n *node) query() *index.Query {
+	switch n.op {
+	case OpContent:
+		return index.RegexpQuery(n.re.Syntax)
+	case OpAnd:
+		q := &index.Query{Op: index.QAll}
+		for _, sub := range n.sub {
+			q = q.And(sub.query())
+		}
+		return q
+	case OpOr:
+		q := &index.Query{Op: index.QNone}
+		for _, sub := range n.sub {
+			q = q.Or(sub.query())
+		}
+		return q
+	}
+	// Name atoms do not constrain the content.
+	return &index.Query{Op: index.QAll}
+}
+
+// hasContent reports whether n contains any content atoms.
+func (n *node) hasContent() bool {
+	if n.op == OpContent {
+		return true
+	}
+	for _, sub := range n.sub {
+		if sub.hasContent() {
+			return true
+		}
+	}
+	return false
+}
+
+// simple reports whether any file that passes the name filter
+// and contains a match for one of n's content atoms matches n.
+// That is true for a single atom, an or of atoms, and an and of
+// one such expression with any number of name-only expressions.
+func (n *node) simple() bool {
+	switch n.op {
+	case OpContent:
+		return true
+	case OpOr:
+		for _, sub := range n.sub {
+			if sub.op != OpContent && (sub.op != OpOr || !sub.simple()) {
+				return false
+			}
+		}
+		return true
+	case OpAnd:
+		content := 0
+		for _, sub := range n.sub {
+			if sub.hasContent() {
+				if content++; content > 1 || !sub.simple() {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// Roots returns a list of directory trees that together contain
+// every file that can match the query, or nil if there is no such list.
+// Callers can use it to restrict the search with Index.PostingQueryPath.
+func (s *Search) Roots() []index.Path {
+	return s.n.roots()
+}
+
+func (n *node) roots() []index.Path {
+	switch n.op {
+	case OpRoot:
+		if !n.not {
+			return []index.Path{n.root}
+		}
+	case OpAnd:
+		// Any one of the subexpressions' trees will do.
+		for _, sub := range n.sub {
+			if r := sub.roots(); r != nil {
+				return r
+			}
+		}
+	case OpOr:
+		var list []index.Path
+		for _, sub := range n.sub {
+			r := sub.roots()
+			if r == nil {
+				return nil
+			}
+			list = append(list, r...)
+		}
+		return list
+	}
+	return nil
+}
+
+// A tri is a three-valued boolean.
+type tri int
+
+const (
+	triFalse tri = iota
+	triTrue
+	triMaybe
+)
+
+func triOf(b bool) tri {
+	if b {
+		return triTrue
+	}
+	return triFalse
+}
+
+// MatchName reports whether a file with the given name can match the query.
+// It returns false only if the name alone rules the file out.
+func (s *Search) MatchName(name string) bool {
+	return s.n.matchName(name) != triFalse
+}
+
+func (n *node) matchName(name string) tri {
+	var t tri
+	switch n.op {
+	case OpContent:
+		return triMaybe
+	case OpAnd:
+		t = triTrue
+		for _, sub := range n.sub {
+			switch sub.matchName(name) {
+			case triFalse:
+				return triFalse
+			case triMaybe:
+				t = triMaybe
+			}
+		}
+		return t
+	case OpOr:
+		t = triFalse
+		for _, sub := range n.sub {
+			switch sub.matchName(name) {
+			case triTrue:
+				return triTrue
+			case triMaybe:
+				t = triMaybe
+			}
+		}
+		return t
+	case OpFile:
+		t = triOf(n.re.MatchString(name, true, true) >= 0)
+	case OpLang:
+		t = triOf(n.lang.match(name))
+	case OpRoot:
+		t = triOf(index.MakePath(name).HasPathPrefix(n.root))
+	}
+	if n.not {
+		t = triOf(t == triFalse)
+	}
+	return t
+}
+
+// Match reports whether the file with the given name and content
+// matches the query.
+func (s *Search) Match(name string, data []byte) bool {
+	return s.n.match(name, data)
+}
+
+func (n *node) match(name string, data []byte) bool {
+	switch n.op {
+	case OpContent:
+		return n.re.Match(data, true, true) >= 0
+	case OpAnd:
+		for _, sub := range n.sub {
+			if !sub.match(name, data) {
+				return false
+			}
+		}
+		return true
+	case OpOr:
+		for _, sub := range n.sub {
+			if sub.match(name, data) {
+				return true
+			}
+		}
+		return false
+	}
+	return n.matchName(name) == triTrue
+}
+
+// A language describes the file names used for a programming language.
+type language struct {
+	ext   []string // file name extensions, including the leading dot
+	names []string // complete file names
+}
+
+// match reports whether name is the name of a file in language l.
+func (l *language) match(name string) bool {
+	// Index names for files inside zip archives use \x01
+	// to separate the archive name from the file name.
+	if i := strings.LastIndexAny(name, "/\\\x01"); i >= 0 {
+		name = name[i+1:]
+	}
+	for _, n := range l.names {
+		if name == n {
+			return true
+		}
+	}
+	for _, ext := range l.ext {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	langC    = &language{ext: []string{".c", ".h"}}
+	langCpp  = &language{ext: []string{".cc", ".cpp", ".cxx", ".c++", ".h", ".hh", ".hpp", ".hxx"}}
+	langJS   = &language{ext: []string{".js", ".mjs", ".cjs", ".jsx"}}
+	langTS   = &language{ext: []string{".ts", ".mts", ".cts", ".tsx"}}
+	langPy   = &language{ext: []string{".py", ".pyi"}}
+	langSh   = &language{ext: []string{".sh", ".bash"}}
+	langYAML = &language{ext: []string{".yaml", ".yml"}}
+	langMake = &language{ext: []string{".mk", ".mak"}, names: []string{"Makefile", "makefile", "GNUmakefile"}}
+	langMd   = &language{ext: []string{".md", ".markdown"}}
+	langCS   = &language{ext: []string{".cs"}}
+)
+
+// languages maps lang: arguments to languages.
+var languages = map[string]*language{
+	"asm":        {ext: []string{".s", ".S", ".asm"}},
+	"bash":       langSh,
+	"c":          langC,
+	"c#":         langCS,
+	"c++":        langCpp,
+	"cpp":        langCpp,
+	"csharp":     langCS,
+	"css":        {ext: []string{".css"}},
+	"dart":       {ext: []string{".dart"}},
+	"go":         {ext: []string{".go"}},
+	"haskell":    {ext: []string{".hs"}},
+	"html":       {ext: []string{".html", ".htm"}},
+	"java":       {ext: []string{".java"}},
+	"javascript": langJS,
+	"js":         langJS,
+	"json":       {ext: []string{".json"}},
+	"kotlin":     {ext: []string{".kt", ".kts"}},
+	"lua":        {ext: []string{".lua"}},
+	"make":       langMake,
+	"makefile":   langMake,
+	"markdown":   langMd,
+	"md":         langMd,
+	"objc":       {ext: []string{".m", ".mm"}},
+	"perl":       {ext: []string{".pl", ".pm"}},
+	"php":        {ext: []string{".php"}},
+	"proto":      {ext: []string{".proto"}},
+	"py":         langPy,
+	"python":     langPy,
+	"ruby":       {ext: []string{".rb"}, names: []string{"Rakefile", "Gemfile"}},
+	"rust":       {ext: []string{".rs"}},
+	"scala":      {ext: []string{".scala"}},
+	"sh":         langSh,
+	"shell":      langSh,
+	"sql":        {ext: []string{".sql"}},
+	"swift":      {ext: []string{".swift"}},
+	"ts":         langTS,
+	"typescript": langTS,
+	"yaml":       langYAML,
+	"yml":        langYAML,
+}
@@ -0,0 +1,172 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Colors holds the ANSI SGR parameters, such as "1;31" for bold red,
+// used to highlight each part of the output. An empty string leaves
+// that part plain. A nil *Colors leaves everything plain.
+type Colors struct {
+	Path  string // file names
+	Line  string // line numbers
+	Match string // matching text
+	Sep   string // the colons separating the other parts
+}
+
+// DefaultColors are the colors GNU grep uses.
+var DefaultColors = Colors{
+	Path:  "35",
+	Line:  "32",
+	Match: "1;31",
+	Sep:   "36",
+}
+
+// ParseColors returns DefaultColors modified by s, which has the form
+// of the CSEARCH_COLORS environment variable: a colon-separated list of
+// name=value settings, where name is path, line, match, or sep,
+// and value is a list of SGR parameters separated by semicolons,
+// or empty to disable that color. For example, "match=4:sep=".
+func ParseColors(s string) (*Colors, error) {
+	c := DefaultColors
+	if s == "" {
+		return &c, nil
+	}
+	for _, f := range strings.Split(s, ":") {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed color setting %q: want name=value", f)
+		}
+		if strings.Trim(value, "0123456789;") != "" {
+			return nil, fmt.Errorf("malformed color %q for %s", value, name)
+		}
+		switch name {
+		case "path":
+			c.Path = value
+		case "line":
+			c.Line = value
+		case "match":
+			c.Match = value
+		case "sep":
+			c.Sep = value
+		default:
+			return nil, fmt.Errorf("unknown color name %q", name)
+		}
+	}
+	return &c, nil
+}
+
+// setColor records the -color flag's when argument.
+func (g *Grep) setColor(when string) error {
+	switch when {
+	case "auto", "always", "never":
+		g.colorWhen = when
+		return nil
+	}
+	return fmt.Errorf("invalid color mode %q: want auto, always, or never", when)
+}
+
+// InitColor sets g.Color according to the -color flag,
+// which is auto unless given. In auto mode, output is highlighted
+// only if g.Stdout is a terminal and the NO_COLOR environment variable
+// is unset or empty. The CSEARCH_COLORS environment variable
+// overrides the default colors (see ParseColors).
+// HTML and JSON output are never highlighted.
+func (g *Grep) InitColor() error {
+	g.Color = nil
+	if g.HTML || g.JSON {
+		return nil
+	}
+	switch g.colorWhen {
+	case "never":
+		return nil
+	case "", "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || !isTerminal(g.Stdout) {
+			return nil
+		}
+	}
+	c, err := ParseColors(os.Getenv("CSEARCH_COLORS"))
+	if err != nil {
+		return fmt.Errorf("CSEARCH_COLORS: %v", err)
+	}
+	g.Color = c
+	return nil
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w any) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// paint returns s wrapped in escapes to display it with the SGR
+// parameters style, or s itself if style is empty.
+func paint(style, s string) string {
+	if style == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
+
+func (c *Colors) path(s string) string {
+	if c == nil {
+		return s
+	}
+	return paint(c.Path, s)
+}
+
+func (c *Colors) line(n int) string {
+	s := fmt.Sprint(n)
+	if c == nil {
+		return s
+	}
+	return paint(c.Line, s)
+}
+
+func (c *Colors) sep(s string) string {
+	if c == nil {
+		return s
+	}
+	return paint(c.Sep, s)
+}
+
+func (c *Colors) match(s string) string {
+	if c == nil {
+		return s
+	}
+	return paint(c.Match, s)
+}
+
+// highlight returns line with the non-empty spans in sub,
+// which are sorted byte offsets in line, painted as matches.
+func (c *Colors) highlight(line []byte, sub [][]int) string {
+	if c == nil || c.Match == "" || len(sub) == 0 {
+		return string(line)
+	}
+	// Leave the newline ending the line plain, even if a multiline
+	// match includes it, so that the escapes do not span lines.
+	n := len(chomp1(line))
+	var b strings.Builder
+	p := 0
+	for _, m := range sub {
+		e := min(m[1], n)
+		if m[0] >= e || m[0] < p {
+			continue
+		}
+		b.Write(line[p:m[0]])
+		b.WriteString(paint(c.Match, string(line[m[0]:e])))
+		p = e
+	}
+	b.Write(line[p:])
+	return b.String()
+}
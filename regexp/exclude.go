@@ -0,0 +1,78 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"flag"
+
+	"github.com/google/codesearch/glob"
+)
+
+// An Exclude is a set of patterns naming files to skip.
+type Exclude struct {
+	// Regexps are matched against the whole file name.
+	Regexps []*Regexp
+
+	// Globs are shell patterns, as in path.Match,
+	// matched against runs of elements in the file name
+	// as described in package glob.
+	Globs []string
+}
+
+// AddFlags adds the repeatable -exclude (glob) and -exclude-regexp
+// flags to the default flag set. There is no short form like -x,
+// which grep uses for matching whole lines.
+func (x *Exclude) AddFlags() {
+	x.addFlags(flag.CommandLine)
+}
+
+func (x *Exclude) addFlags(fs *flag.FlagSet) {
+	fs.Func("exclude", "skip files with names matching `glob` (repeatable)", x.AddGlob)
+	fs.Func("exclude-regexp", "skip files with names matching `regexp` (repeatable)", x.AddRegexp)
+}
+
+// AddRegexp adds the regular expression expr to the exclusions.
+func (x *Exclude) AddRegexp(expr string) error {
+	re, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	x.Regexps = append(x.Regexps, re)
+	return nil
+}
+
+// AddGlob adds the glob pattern to the exclusions.
+func (x *Exclude) AddGlob(pattern string) error {
+	pattern, err := glob.Clean(pattern)
+	if err != nil {
+		return err
+	}
+	x.Globs = append(x.Globs, pattern)
+	return nil
+}
+
+// Empty reports whether x excludes nothing.
+func (x *Exclude) Empty() bool {
+	return len(x.Regexps) == 0 && len(x.Globs) == 0
+}
+
+// Match reports whether the named file should be skipped.
+func (x *Exclude) Match(name string) bool {
+	for _, re := range x.Regexps {
+		if re.MatchString(name, true, true) >= 0 {
+			return true
+		}
+	}
+	if len(x.Globs) == 0 {
+		return false
+	}
+	elem := glob.Split(name)
+	for _, pattern := range x.Globs {
+		if glob.Match(pattern, elem) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"unicode/utf8"
+
+	"github.com/google/codesearch/sparse"
+)
+
+// The DFA in match.go only finds the end of the line containing a match.
+// To find the matches themselves, FindAllIndex uses the DFA to find
+// a matching line and then runs a Pike VM over that line.
+// The VM simulates the byte program directly, tracking for each
+// instruction the earliest position at which a thread reaching it started,
+// which is enough to find leftmost-longest matches.
+
+// A finder holds the state for the Pike VM.
+type finder struct {
+	prog       *syntax.Prog
+	run, next  sparse.Set // threads, by instruction
+	runStart   []int      // start position of thread at each instruction in run
+	nextStart  []int      // start position of thread at each instruction in next
+	stack      []uint32   // addthread work list
+	line       []byte     // line (or in multiline mode, text) being searched
+	begin, end bool       // line is at the beginning, end of the text
+}
+
+func (f *finder) init(prog *syntax.Prog) {
+	n := uint32(len(prog.Inst))
+	f.prog = prog
+	f.run.Init(n)
+	f.next.Init(n)
+	f.runStart = make([]int, n)
+	f.nextStart = make([]int, n)
+}
+
+// FindAllIndex returns the start and end of successive non-overlapping
+// matches of r in b, as in the standard regexp package, except that
+// matches are leftmost-longest and, unless r was compiled by
+// CompileMultiline, cannot span lines.
+// An empty match abutting a preceding match is ignored.
+// If n ≥ 0, FindAllIndex returns at most n matches.
+func (r *Regexp) FindAllIndex(b []byte, n int) [][]int {
+	if r.fuzzy != nil {
+		return nil
+	}
+	mc := r.get()
+	defer r.put(mc)
+	return r.findAll(mc, b, n)
+}
+
+// findAll is FindAllIndex using the machine mc, for a Regexp that is not fuzzy.
+func (r *Regexp) findAll(mc *machine, b []byte, n int) [][]int {
+	if mc.f.prog == nil {
+		mc.f.init(r.prog)
+	}
+	if r.multi {
+		if mc.m.match(b, true, true) < 0 {
+			return nil
+		}
+		return mc.f.findLine(b, 0, true, true, nil, n)
+	}
+	var out [][]int
+	for pos := 0; pos <= len(b) && (n < 0 || len(out) < n); {
+		// Use the DFA to find the next matching line.
+		e := mc.m.match(b[pos:], pos == 0, true)
+		if e < 0 {
+			break
+		}
+		end := pos + e
+		start := pos + bytes.LastIndexByte(b[pos:end], '\n') + 1
+		out = mc.f.findLine(b[start:end], start, start == 0, end == len(b), out, n)
+		pos = end + 1
+	}
+	return out
+}
+
+// findLine returns the matches in line, which must not contain
+// a newline, using mc, a machine from r.get, which is nil if r is fuzzy.
+// The begin and end flags report whether the line is
+// at the beginning and end of the text.
+func (r *Regexp) findLine(mc *machine, line []byte, begin, end bool) [][]int {
+	if r.fuzzy != nil {
+		return nil
+	}
+	if mc.f.prog == nil {
+		mc.f.init(r.prog)
+	}
+	return mc.f.findLine(line, 0, begin, end, nil, -1)
+}
+
+// findLine appends to out the matches in line, which is at offset off
+// in the text being searched, stopping when out has n matches.
+func (f *finder) findLine(line []byte, off int, begin, end bool, out [][]int, n int) [][]int {
+	f.line, f.begin, f.end = line, begin, end
+	prevEnd := -1
+	for pos := 0; pos <= len(line) && (n < 0 || len(out) < n); {
+		s, e := f.find(pos)
+		if s < 0 {
+			break
+		}
+		if s == e && s == prevEnd {
+			// Empty match abutting the previous match: skip ahead a rune.
+			_, size := utf8.DecodeRune(line[pos:])
+			pos += max(size, 1)
+			continue
+		}
+		out = append(out, []int{off + s, off + e})
+		prevEnd = e
+		pos = e
+		if s == e {
+			_, size := utf8.DecodeRune(line[pos:])
+			pos += max(size, 1)
+		}
+	}
+	return out
+}
+
+// find returns the leftmost-longest match in f.line starting at or after pos.
+// It returns -1, -1 if there is no match.
+func (f *finder) find(pos int) (start, end int) {
+	start, end = -1, -1
+	f.run.Reset()
+	for i := pos; ; i++ {
+		if start < 0 && (i >= len(f.line) || utf8.RuneStart(f.line[i])) {
+			// No match yet: start a new thread here,
+			// unless we are in the middle of a UTF-8 sequence.
+			// It has the latest start, so it goes last.
+			f.add(&f.run, f.runStart, uint32(f.prog.Start), i, i)
+		}
+		if f.run.Len() == 0 && (start >= 0 || i >= len(f.line)) {
+			break
+		}
+		c := endText
+		if i < len(f.line) {
+			c = int(f.line[i])
+		}
+		f.next.Reset()
+		for _, pc := range f.run.Dense() {
+			s := f.runStart[pc]
+			if start >= 0 && s > start {
+				// Threads are in order of start position,
+				// so this thread and the rest cannot beat the match.
+				break
+			}
+			inst := &f.prog.Inst[pc]
+			switch inst.Op {
+			case syntax.InstMatch:
+				if start < 0 || s < start || s == start && i > end {
+					start, end = s, i
+				}
+			case instByteRange:
+				if c == endText {
+					break
+				}
+				lo := int((inst.Arg >> 8) & 0xFF)
+				hi := int(inst.Arg & 0xFF)
+				ch := c
+				if inst.Arg&argFold != 0 && 'a' <= ch && ch <= 'z' {
+					ch += 'A' - 'a'
+				}
+				if lo <= ch && ch <= hi {
+					f.add(&f.next, f.nextStart, inst.Out, s, i+1)
+				}
+			}
+		}
+		if c == endText {
+			break
+		}
+		f.run, f.next = f.next, f.run
+		f.runStart, f.nextStart = f.nextStart, f.runStart
+	}
+	return start, end
+}
+
+// add adds the thread at pc, started at start, to q,
+// following empty-width instructions at position i.
+// If q already has a thread at pc, it started no later
+// and so takes precedence.
+func (f *finder) add(q *sparse.Set, starts []int, pc uint32, start, i int) {
+	flag := f.flags(i)
+	f.stack = append(f.stack[:0], pc)
+	for len(f.stack) > 0 {
+		pc := f.stack[len(f.stack)-1]
+		f.stack = f.stack[:len(f.stack)-1]
+		if q.Has(pc) {
+			continue
+		}
+		q.Add(pc)
+		starts[pc] = start
+		inst := &f.prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstCapture, syntax.InstNop:
+			f.stack = append(f.stack, inst.Out)
+		case syntax.InstAlt, syntax.InstAltMatch:
+			f.stack = append(f.stack, inst.Arg, inst.Out)
+		case syntax.InstEmptyWidth:
+			if syntax.EmptyOp(inst.Arg)&^flag == 0 {
+				f.stack = append(f.stack, inst.Out)
+			}
+		}
+	}
+}
+
+// flags returns the empty-width conditions that hold at position i in f.line.
+// In multiline mode, f.line can contain newlines.
+func (f *finder) flags(i int) syntax.EmptyOp {
+	var flag syntax.EmptyOp
+	before, after := -1, -1
+	if i > 0 {
+		before = int(f.line[i-1])
+	} else if f.begin {
+		flag |= syntax.EmptyBeginText
+	}
+	if i == 0 || before == '\n' {
+		flag |= syntax.EmptyBeginLine
+	}
+	if i < len(f.line) {
+		after = int(f.line[i])
+	} else if f.end {
+		flag |= syntax.EmptyEndText
+	}
+	if i == len(f.line) || after == '\n' {
+		flag |= syntax.EmptyEndLine
+	}
+	if isWordByte(before) != isWordByte(after) {
+		flag |= syntax.EmptyWordBoundary
+	} else {
+		flag |= syntax.EmptyNoWordBoundary
+	}
+	return flag
+}
@@ -0,0 +1,122 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Approximate matching uses the bit-parallel algorithm of Wu and Manber,
+// "Fast Text Searching Allowing Errors" (CACM, October 1992).
+// For each d ≤ k, bit i of state[d] records whether the first i+1
+// characters of the literal match some text ending at the current
+// position with at most d insertions, deletions, and substitutions.
+// Each character of text updates all the bits of a state at once,
+// so the cost of matching is O(k) per character, independent of the
+// length of the literal, which is limited to 64 characters.
+
+// maxFuzzyLen is the length of the longest literal CompileFuzzy accepts.
+const maxFuzzyLen = 64
+
+// A fuzzy is an approximate matcher for a literal.
+type fuzzy struct {
+	k     int                   // errors allowed
+	goal  uint64                // bit for a match of the whole literal
+	ascii [utf8.RuneSelf]uint64 // character masks for ASCII
+	other map[rune]uint64       // character masks for other runes
+}
+
+// CompileFuzzy returns a Regexp that matches text within Levenshtein
+// distance k of the literal lit, counting each inserted, deleted,
+// or substituted character as one error. Like any other Regexp,
+// its matches cannot span lines.
+// The literal must not contain a newline, must be at most 64 characters
+// long, and must be longer than k.
+// The Regexp's Syntax is nil, and its FindAllIndex returns nil:
+// approximate matching reports only which lines match.
+func CompileFuzzy(lit string, k int) (*Regexp, error) {
+	n := utf8.RuneCountInString(lit)
+	switch {
+	case k < 0:
+		return nil, fmt.Errorf("invalid fuzzy distance %d", k)
+	case n <= k:
+		return nil, fmt.Errorf("fuzzy literal %q must be longer than the distance %d", lit, k)
+	case n > maxFuzzyLen:
+		return nil, fmt.Errorf("fuzzy literal %q is longer than %d characters", lit, maxFuzzyLen)
+	case !utf8.ValidString(lit):
+		return nil, errors.New("fuzzy literal is not valid UTF-8")
+	case bytes.IndexByte([]byte(lit), '\n') >= 0:
+		return nil, errors.New("fuzzy literal contains a newline")
+	}
+	f := &fuzzy{k: k, goal: 1 << (n - 1)}
+	i := 0
+	for _, r := range lit {
+		if r < utf8.RuneSelf {
+			f.ascii[r] |= 1 << i
+		} else {
+			if f.other == nil {
+				f.other = make(map[rune]uint64)
+			}
+			f.other[r] |= 1 << i
+		}
+		i++
+	}
+	return &Regexp{expr: lit, fuzzy: f}, nil
+}
+
+// match returns the end of the first line in b containing a match,
+// as in Regexp.Match, or -1 if there is no match.
+func (f *fuzzy) match(b []byte) int {
+	var buf [8]uint64
+	state := buf[:0]
+	if f.k+1 > len(buf) {
+		state = make([]uint64, 0, f.k+1)
+	}
+	state = state[:f.k+1]
+	reset := func() {
+		for d := range state {
+			state[d] = 1<<d - 1
+		}
+	}
+	reset()
+	for i := 0; i < len(b); {
+		c, size := rune(b[i]), 1
+		if c == '\n' {
+			reset()
+			i++
+			continue
+		}
+		var mask uint64
+		if c < utf8.RuneSelf {
+			mask = f.ascii[c]
+		} else {
+			c, size = utf8.DecodeRune(b[i:])
+			mask = f.other[c]
+		}
+		i += size
+
+		// prev is state[d-1] before this character.
+		prev := state[0]
+		state[0] = (prev<<1 | 1) & mask
+		for d := 1; d < len(state); d++ {
+			old := state[d]
+			state[d] = (old<<1|1)&mask | // match
+				prev | // insertion
+				(prev|state[d-1])<<1 | // substitution, deletion
+				(1<<d - 1)
+			prev = old
+		}
+		if state[f.k]&f.goal != 0 {
+			if j := bytes.IndexByte(b[i:], '\n'); j >= 0 {
+				return i + j
+			}
+			return len(b)
+		}
+	}
+	return -1
+}
@@ -0,0 +1,265 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// JSON output follows the schema of ripgrep's --json flag,
+// so that tools written for ripgrep can read it.
+// Each line of output is one event:
+//
+//	{"type":"begin","data":{"path":{"text":"a.go"}}}
+//	{"type":"context","data":{"path":...,"lines":{"text":"x\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}
+//	{"type":"match","data":{"path":...,"lines":{"text":"abc\n"},"line_number":2,"absolute_offset":2,
+//		"submatches":[{"match":{"text":"b"},"start":1,"end":2}]}}
+//	{"type":"end","data":{"path":...,"binary_offset":null,"stats":{...}}}
+//	{"type":"summary","data":{"elapsed_total":{...},"stats":{...}}}
+//
+// A file gets begin and end events only if it has matches.
+// A file listed by List, which matches by name alone,
+// gets begin and end events with nothing between them.
+// Text that is not valid UTF-8 is written as {"bytes":"base64 data"}.
+//
+// Two fields are additions to ripgrep's schema. An end event has
+// "limited":true if the file had more matches than the -m limit allows.
+// A summary event has "incomplete" set to the note Finish prints
+// if the search stopped early and, if g.Stats is set, "search" set to
+// the statistics -stats prints.
+
+type jsonEvent struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+type jsonBegin struct {
+	Path jsonText `json:"path"`
+}
+
+type jsonMatch struct {
+	Path           jsonText       `json:"path"`
+	Lines          jsonText       `json:"lines"`
+	LineNumber     int            `json:"line_number"`
+	AbsoluteOffset int64          `json:"absolute_offset"`
+	Submatches     []jsonSubmatch `json:"submatches"`
+}
+
+type jsonSubmatch struct {
+	Match jsonText `json:"match"`
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+}
+
+type jsonEnd struct {
+	Path         jsonText  `json:"path"`
+	BinaryOffset *int64    `json:"binary_offset"`
+	Stats        jsonStats `json:"stats"`
+	Limited      bool      `json:"limited,omitempty"` // not in ripgrep
+}
+
+type jsonSummary struct {
+	ElapsedTotal jsonDuration `json:"elapsed_total"`
+	Stats        jsonStats    `json:"stats"`
+	Incomplete   string       `json:"incomplete,omitempty"` // not in ripgrep
+	Search       *jsonSearch  `json:"search,omitempty"`     // not in ripgrep
+}
+
+// jsonSearch is the JSON form of Stats.
+type jsonSearch struct {
+	QuerySize     *int         `json:"query_trigrams,omitempty"`
+	Candidates    *int         `json:"candidate_files,omitempty"`
+	FilesRead     int          `json:"files_read"`
+	FilesMissing  int          `json:"files_missing"`
+	BytesSearched int64        `json:"bytes_searched"`
+	FilesMatched  int          `json:"files_matched"`
+	Matches       int          `json:"matches"`
+	Phases        []jsonPhase  `json:"phases"`
+	Elapsed       jsonDuration `json:"elapsed"`
+}
+
+type jsonPhase struct {
+	Name    string       `json:"name"`
+	Elapsed jsonDuration `json:"elapsed"`
+}
+
+func makeJSONSearch(s *Stats) *jsonSearch {
+	js := &jsonSearch{
+		FilesRead:     s.Files,
+		FilesMissing:  s.Missing,
+		BytesSearched: s.Bytes,
+		FilesMatched:  s.FilesMatched,
+		Matches:       s.Matches,
+		Phases:        []jsonPhase{},
+		Elapsed:       makeJSONDuration(s.Total),
+	}
+	if s.Indexed {
+		js.QuerySize = &s.QuerySize
+		js.Candidates = &s.Candidates
+	}
+	for _, p := range s.Phases {
+		js.Phases = append(js.Phases, jsonPhase{p.Name, makeJSONDuration(p.Elapsed)})
+	}
+	return js
+}
+
+type jsonStats struct {
+	Elapsed           jsonDuration `json:"elapsed"`
+	Searches          int          `json:"searches"`
+	SearchesWithMatch int          `json:"searches_with_match"`
+	BytesSearched     int64        `json:"bytes_searched"`
+	BytesPrinted      int64        `json:"bytes_printed"`
+	MatchedLines      int          `json:"matched_lines"`
+	Matches           int          `json:"matches"`
+}
+
+func (s *jsonStats) add(t *jsonStats) {
+	s.Searches += t.Searches
+	s.SearchesWithMatch += t.SearchesWithMatch
+	s.BytesSearched += t.BytesSearched
+	s.BytesPrinted += t.BytesPrinted
+	s.MatchedLines += t.MatchedLines
+	s.Matches += t.Matches
+}
+
+type jsonDuration struct {
+	Secs  int64  `json:"secs"`
+	Nanos int32  `json:"nanos"`
+	Human string `json:"human"`
+}
+
+func makeJSONDuration(d time.Duration) jsonDuration {
+	return jsonDuration{
+		Secs:  int64(d / time.Second),
+		Nanos: int32(d % time.Second),
+		Human: fmt.Sprintf("%.6fs", d.Seconds()),
+	}
+}
+
+// jsonText is text that is written as {"text":"..."}
+// if it is valid UTF-8 and as {"bytes":"..."} otherwise.
+type jsonText []byte
+
+func (t jsonText) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(t) {
+		return json.Marshal(struct {
+			Text string `json:"text"`
+		}{string(t)})
+	}
+	return json.Marshal(struct {
+		Bytes string `json:"bytes"`
+	}{base64.StdEncoding.EncodeToString(t)})
+}
+
+// jsonState is the state of JSON output, kept in a Grep.
+type jsonState struct {
+	start   time.Time // when the first file was searched
+	began   bool      // begin event written for current file
+	limited bool      // current file cut short by FileLimit
+	file    jsonStats // stats for current file
+	total   jsonStats // stats for all files
+}
+
+// writeJSON writes the event with the given type and data.
+func (g *Grep) writeJSON(typ string, data any) {
+	b, err := json.Marshal(jsonEvent{typ, data})
+	if err != nil {
+		// Only the types above are marshaled.
+		bug()
+	}
+	b = append(b, '\n')
+	g.Stdout.Write(b)
+	g.json.file.BytesPrinted += int64(len(b))
+}
+
+// jsonStart prepares to write events for a new file.
+func (g *Grep) jsonStart() {
+	if g.json.start.IsZero() {
+		g.json.start = time.Now()
+	}
+	g.json.began = false
+	g.json.limited = false
+	g.json.file = jsonStats{Searches: 1}
+}
+
+// A Lines is a match or context event, as reported to Grep.OnLines.
+type Lines struct {
+	Name       string
+	Context    bool    // the lines are context, not a match
+	Lineno     int     // number of the first line, or 0 for a file listed by List
+	Offset     int64   // byte offset of the first line in the text, as converted to UTF-8
+	Text       []byte  // the lines, with their newlines; valid only during the call
+	Submatches [][]int // the matches, as byte offsets in Text
+}
+
+// events reports whether g reports match and context events,
+// as JSON or to OnLines.
+func (g *Grep) events() bool {
+	return g.JSON || g.OnLines != nil
+}
+
+// jsonLines writes a match or context event for lines,
+// which are at the given offset in the text and begin with line lineno,
+// or reports it to g.OnLines.
+// The submatches are byte offsets in lines.
+func (g *Grep) jsonLines(typ, name string, lines []byte, offset int64, lineno int, sub [][]int) {
+	if g.OnLines != nil {
+		g.OnLines(&Lines{name, typ == "context", lineno, offset, lines, sub})
+		return
+	}
+	if !g.json.began {
+		g.json.began = true
+		g.writeJSON("begin", jsonBegin{jsonText(name)})
+	}
+	m := jsonMatch{
+		Path:           jsonText(name),
+		Lines:          jsonText(lines),
+		LineNumber:     lineno,
+		AbsoluteOffset: offset,
+		Submatches:     []jsonSubmatch{},
+	}
+	for _, s := range sub {
+		m.Submatches = append(m.Submatches, jsonSubmatch{jsonText(lines[s[0]:s[1]]), s[0], s[1]})
+	}
+	if typ == "match" {
+		g.json.file.MatchedLines += countNL(chomp1(lines)) + 1
+		g.json.file.Matches += max(len(sub), 1)
+	}
+	g.writeJSON(typ, m)
+}
+
+// jsonEnd finishes the events for the named file,
+// from which g read n bytes, starting at the given time.
+func (g *Grep) jsonEnd(name string, n int64, start time.Time) {
+	st := &g.json.file
+	st.BytesSearched = n
+	st.Elapsed = makeJSONDuration(time.Since(start))
+	if g.json.began {
+		st.SearchesWithMatch = 1
+	}
+	g.json.total.add(st)
+	if g.json.began {
+		g.writeJSON("end", jsonEnd{Path: jsonText(name), Stats: *st, Limited: g.json.limited})
+	}
+}
+
+// jsonSummary writes the final summary event,
+// with msg explaining why the results are incomplete, if they are.
+func (g *Grep) jsonSummary(msg string) {
+	elapsed := time.Duration(0)
+	if !g.json.start.IsZero() {
+		elapsed = time.Since(g.json.start)
+	}
+	g.json.total.Elapsed = makeJSONDuration(elapsed)
+	sum := jsonSummary{makeJSONDuration(elapsed), g.json.total, msg, nil}
+	if g.Stats != nil {
+		sum.Search = makeJSONSearch(g.Stats)
+	}
+	g.writeJSON("summary", sum)
+}
@@ -6,17 +6,26 @@ package regexp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"html"
 	"io"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp/syntax"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+	"unsafe"
 
+	"github.com/google/codesearch/charset"
 	"github.com/google/codesearch/sparse"
 )
 
@@ -27,6 +36,53 @@ type matcher struct {
 	start     *dstate            // start state
 	startLine *dstate            // start state for beginning of line
 	z1, z2    nstate             // two temporary nstates
+	multi     bool               // matches can span lines
+
+	// The dstate cache is limited to about limit bytes.
+	// When it fills, cache flushes it and starts over.
+	// If the cache keeps filling before it has been used for
+	// many bytes of input, the matcher gives up on the DFA
+	// and simulates the NFA directly instead.
+	limit   int   // cache size limit in bytes
+	size    int   // approximate cache size in bytes
+	nfa     bool  // use NFA simulation instead of DFA
+	thrash  int   // number of consecutive unproductive flushes
+	created int64 // number of dstates created
+	flushes int64 // number of cache flushes
+	built   int   // dstates created since last flush
+	scanned int64 // bytes scanned by completed match calls
+	pos     int   // bytes scanned by current match call, as of last computeNext
+	mark    int64 // bytes scanned as of last flush
+}
+
+// DefaultCacheLimit is the default limit on the memory used
+// by a Regexp's cache of DFA states.
+const DefaultCacheLimit = 8 << 20
+
+const (
+	// dstateSize is the approximate memory used by a cached dstate,
+	// not counting its encoding.
+	dstateSize = int(unsafe.Sizeof(dstate{})) + 64
+
+	// minCacheStates is the minimum number of dstates
+	// a cache can hold, no matter the limit.
+	minCacheStates = 8
+
+	// A flush is unproductive if the cache was used for fewer than
+	// thrashBytes bytes of input per dstate created.
+	// After maxThrash consecutive unproductive flushes,
+	// the matcher switches to NFA simulation.
+	thrashBytes = 10
+	maxThrash   = 3
+)
+
+// CacheStats describes the state of a Regexp's DFA cache.
+type CacheStats struct {
+	States  int   // dstates currently cached
+	Bytes   int   // approximate memory used by the cache
+	Created int64 // dstates created
+	Flushes int64 // cache flushes
+	NFA     bool  // matching has fallen back to NFA simulation
 }
 
 // An nstate corresponds to an NFA state.
@@ -130,21 +186,76 @@ func init() {
 // init initializes the matcher.
 func (m *matcher) init(prog *syntax.Prog) error {
 	m.prog = prog
+	m.limit = DefaultCacheLimit
 	m.dstate = make(map[string]*dstate)
 
 	m.z1.q.Init(uint32(len(prog.Inst)))
 	m.z2.q.Init(uint32(len(prog.Inst)))
 
-	m.addq(&m.z1.q, uint32(prog.Start), syntax.EmptyBeginLine|syntax.EmptyBeginText)
-	m.z1.flag = flagBOL | flagBOT
-	m.start = m.cache(&m.z1)
+	m.initStart()
+	return nil
+}
 
-	m.z1.q.Reset()
-	m.addq(&m.z1.q, uint32(prog.Start), syntax.EmptyBeginLine)
-	m.z1.flag = flagBOL
+// initStart sets the start states.
+func (m *matcher) initStart() {
+	m.startState(&m.z1, true)
+	m.start = m.cache(&m.z1)
+	m.startState(&m.z1, false)
 	m.startLine = m.cache(&m.z1)
+}
 
-	return nil
+// startState sets z to the NFA state at the beginning of a line
+// and, if beginText is set, the beginning of the text.
+func (m *matcher) startState(z *nstate, beginText bool) {
+	z.q.Reset()
+	z.partial = 0
+	if beginText {
+		m.addq(&z.q, uint32(m.prog.Start), syntax.EmptyBeginLine|syntax.EmptyBeginText)
+		z.flag = flagBOL | flagBOT
+	} else {
+		m.addq(&z.q, uint32(m.prog.Start), syntax.EmptyBeginLine)
+		z.flag = flagBOL
+	}
+}
+
+// setCacheLimit sets the cache limit to n bytes,
+// flushing the cache if it is already bigger.
+func (m *matcher) setCacheLimit(n int) {
+	m.limit = max(n, minCacheStates*dstateSize)
+	if m.size > m.limit {
+		m.flush()
+	}
+}
+
+// flush empties the dstate cache, keeping only new start states.
+// States already in use by a running match remain valid,
+// but they are no longer reachable from the cache.
+func (m *matcher) flush() {
+	m.flushes++
+	progress := m.scanned + int64(m.pos) - m.mark
+	m.mark = m.scanned + int64(m.pos)
+	if progress < int64(thrashBytes*m.built) {
+		m.thrash++
+		if m.thrash >= maxThrash {
+			m.nfa = true
+		}
+	} else {
+		m.thrash = 0
+	}
+	m.built = 0
+	m.size = 0
+	m.dstate = make(map[string]*dstate)
+	m.initStart()
+}
+
+func (m *matcher) stats() CacheStats {
+	return CacheStats{
+		States:  len(m.dstate),
+		Bytes:   m.size,
+		Created: m.created,
+		Flushes: m.flushes,
+		NFA:     m.nfa,
+	}
 }
 
 // stepEmpty steps runq to nextq expanding according to flag.
@@ -211,8 +322,18 @@ const endText = -1
 
 // computeNext computes the next DFA state if we're in d reading c (an input byte or endText).
 func (m *matcher) computeNext(d *dstate, c int) *dstate {
-	this, next := &m.z1, &m.z2
-	this.dec(d.enc)
+	m.z1.dec(d.enc)
+	if m.step(&m.z1, &m.z2, c) {
+		return &dmatch
+	}
+	return m.cache(&m.z1)
+}
+
+// step updates the NFA state z to the state after reading c
+// (an input byte or endText), using tmp as temporary storage.
+// It reports whether a match ends immediately before c.
+func (m *matcher) step(z, tmp *nstate, c int) (match bool) {
+	this, next := z, tmp
 
 	// compute flags in effect before c
 	flag := syntax.EmptyOp(0)
@@ -260,10 +381,8 @@ func (m *matcher) computeNext(d *dstate, c int) *dstate {
 	}
 
 	// re-add start, process rune + expand according to flags.
-	if m.stepByte(&this.q, &next.q, c, flag) {
-		return &dmatch
-	}
-	return m.cache(next)
+	// The result ends up back in z.
+	return m.stepByte(&this.q, &next.q, c, flag)
 }
 
 func (m *matcher) cache(z *nstate) *dstate {
@@ -273,6 +392,13 @@ func (m *matcher) cache(z *nstate) *dstate {
 		return d
 	}
 
+	size := dstateSize + len(enc)
+	if m.size+size > m.limit {
+		m.flush()
+	}
+	m.size += size
+	m.created++
+	m.built++
 	d = &dstate{enc: enc}
 	m.dstate[enc] = d
 	d.matchNL = m.computeNext(d, '\n') == &dmatch
@@ -283,6 +409,13 @@ func (m *matcher) cache(z *nstate) *dstate {
 func (m *matcher) match(b []byte, beginText, endText bool) (end int) {
 	//	fmt.Printf("%v\n", m.prog)
 
+	if m.nfa {
+		return m.matchNFA(b, beginText, endText)
+	}
+	if m.multi {
+		return m.matchMulti(b, beginText, endText)
+	}
+	defer m.done(len(b))
 	d := m.startLine
 	if beginText {
 		d = m.start
@@ -298,6 +431,7 @@ func (m *matcher) match(b []byte, beginText, endText bool) (end int) {
 				}
 				d1 = m.startLine
 			} else {
+				m.pos = i
 				d1 = m.computeNext(d, int(c))
 			}
 			d.next[c] = d1
@@ -313,6 +447,10 @@ func (m *matcher) match(b []byte, beginText, endText bool) (end int) {
 }
 
 func (m *matcher) matchString(b string, beginText, endText bool) (end int) {
+	if m.nfa || m.multi {
+		return m.match([]byte(b), beginText, endText)
+	}
+	defer m.done(len(b))
 	d := m.startLine
 	if beginText {
 		d = m.start
@@ -327,6 +465,7 @@ func (m *matcher) matchString(b string, beginText, endText bool) (end int) {
 				}
 				d1 = m.startLine
 			} else {
+				m.pos = i
 				d1 = m.computeNext(d, int(c))
 			}
 			d.next[c] = d1
@@ -339,6 +478,71 @@ func (m *matcher) matchString(b string, beginText, endText bool) (end int) {
 	return -1
 }
 
+// matchMulti is like match for a multiline matcher,
+// in which \n is an ordinary byte. It returns the end
+// of the first match found, or -1 if there is none.
+func (m *matcher) matchMulti(b []byte, beginText, endText bool) (end int) {
+	defer m.done(len(b))
+	d := m.startLine
+	if beginText {
+		d = m.start
+	}
+	for i, c := range b {
+		d1 := d.next[c]
+		if d1 == nil {
+			m.pos = i
+			d1 = m.computeNext(d, int(c))
+			d.next[c] = d1
+		}
+		if d1 == &dmatch {
+			return i
+		}
+		d = d1
+	}
+	if endText && d.matchEOT {
+		return len(b)
+	}
+	return -1
+}
+
+// done records that a match call has finished scanning n bytes.
+// Calls that return early count as scanning the whole input,
+// which only makes thrashing harder to detect.
+func (m *matcher) done(n int) {
+	m.scanned += int64(n)
+	m.pos = 0
+}
+
+// matchNFA is like match but simulates the NFA directly,
+// without building DFA states. It is used when the DFA cache thrashes.
+func (m *matcher) matchNFA(b []byte, beginText, atEnd bool) (end int) {
+	z, tmp := &m.z1, &m.z2
+	m.startState(z, beginText)
+	for i, c := range b {
+		if m.step(z, tmp, int(c)) {
+			if c == '\n' || m.multi {
+				return i
+			}
+			// Matched; the result is the end of the line.
+			if j := bytes.IndexByte(b[i:], '\n'); j >= 0 {
+				return i + j
+			}
+			return len(b)
+		}
+		if c == '\n' && !m.multi {
+			m.startState(z, false)
+		}
+	}
+	if atEnd {
+		if m.step(z, tmp, endText) {
+			return len(b)
+		}
+	} else if !m.multi && m.step(z, tmp, '\n') {
+		return len(b)
+	}
+	return -1
+}
+
 // isWordByte reports whether the byte c is a word character: ASCII only.
 // This is used to implement \b and \B.  This is not right for Unicode, but:
 //   - it's hard to get right in a byte-at-a-time matching world
@@ -362,6 +566,7 @@ type Grep struct {
 	N bool // N flag - print line numbers
 	H bool // H flag - do not print file names
 	V bool // V flag - print non-matching lines (only for cgrep, not csearch)
+	O bool // O flag - print only the matching parts of lines
 
 	HTML    bool // emit HTML output for csweb
 	Match   bool // were any matches found?
@@ -369,10 +574,68 @@ type Grep struct {
 	Limit   int  // stop after this many matches
 	Limited bool // stopped because of limit
 
-	PreContext  int // number of lines to print after
-	PostContext int // number of lines to print before
+	FileLimit    int // stop reading a file after this many matches
+	FilesLimited int // how many files were cut short by FileLimit
+
+	Files        int // how many files were read?
+	FilesMatched int // how many files had matches?
+	Missing      int // how many files could not be opened?
+
+	// With context, Grep prints lines as grep -A, -B, and -C do:
+	// name:lineno:text for matching lines and name-lineno-text for
+	// context, with a -- line between groups that are not adjacent.
+	// In the Compact style, Grep instead prints a name:lineno: header
+	// for each match, followed by the match and its context, indented
+	// by a tab after removing their common indentation,
+	// with >> marking the matching line.
+	PreContext  int  // number of lines to print before
+	PostContext int  // number of lines to print after
+	Compact     bool // print context in the Compact style
+
+	MaxBytes   int64 // stop after reading this many bytes (0 for no limit)
+	Bytes      int64 // how many bytes were read?
+	Incomplete error // if non-nil, why the search stopped early
+
+	Encodings *charset.Map // encodings of files without byte order marks
+
+	JSON bool // emit JSON Lines output, as in ripgrep --json (see json.go)
+
+	Color *Colors // if non-nil, highlight output with these colors (see color.go)
+
+	// For jumping to matches from an editor, Column prints the column
+	// of the first match on each line after the line number, and Vimgrep
+	// prints a line for every match, as path:lineno:column:text.
+	// Columns count bytes from 1, or runes if ColumnRunes is set.
+	Column      bool
+	Vimgrep     bool
+	ColumnRunes bool
+	Dir         string // if set, print names of files in Dir relative to it
+
+	// MaxColumns, if positive, limits the bytes printed of each line.
+	// A longer line is cut down to a part beginning shortly before
+	// its first match, with notes giving the number of bytes omitted.
+	// It does not apply to JSON output or to the matches printed for O.
+	MaxColumns int
 
-	buf []byte
+	// If Stats is non-nil, Finish completes and prints it (see stats.go).
+	Stats *Stats
+
+	// If OnMatch is non-nil, Grep calls it for each match it prints
+	// or counts, with the line number and text of the line containing
+	// the start of the match, not including the newline.
+	OnMatch func(name string, lineno int, line []byte)
+
+	// If OnLines is non-nil, Grep reports the lines it would write
+	// as JSON match and context events by calling it instead, for
+	// programs that want the results as data, and writes no JSON.
+	// The L and C flags take precedence over it.
+	OnLines func(*Lines)
+
+	buf       []byte
+	mc        *machine // Regexp's machine while reading a file; nil if fuzzy
+	json      jsonState
+	colorWhen string // -color flag
+	grouped   bool   // a group of lines with context has been printed
 }
 
 func (g *Grep) AddFlags() {
@@ -380,6 +643,9 @@ func (g *Grep) AddFlags() {
 	flag.BoolVar(&g.C, "c", false, "print match counts only")
 	flag.BoolVar(&g.N, "n", false, "show line numbers")
 	flag.BoolVar(&g.H, "h", false, "omit file names")
+	flag.BoolVar(&g.O, "o", false, "print only the matching parts of lines")
+	flag.BoolVar(&g.JSON, "json", false, "print results as JSON Lines")
+	flag.Func("color", "highlight output: `when` is auto, always, or never (default auto)", g.setColor)
 	flag.IntVar(&g.PreContext, "B", 0, "show `n` lines before match")
 	flag.IntVar(&g.PostContext, "A", 0, "show `n` lines after match")
 	flag.Func("C", "show `n` lines before and after match", func(s string) error {
@@ -391,6 +657,24 @@ func (g *Grep) AddFlags() {
 		g.PostContext = n
 		return nil
 	})
+	flag.BoolVar(&g.Compact, "compact", false, "print context in compact form")
+	flag.IntVar(&g.FileLimit, "m", 0, "stop reading a file after `n` matches")
+	flag.IntVar(&g.Limit, "max-total", 0, "stop searching after `n` matches")
+	flag.BoolVar(&g.Column, "column", false, "show column of first match on each line")
+	flag.BoolVar(&g.Vimgrep, "vimgrep", false, "show every match as path:line:column:text")
+	flag.Func("column-unit", "count columns in `unit`s: bytes or runes (default bytes)", func(s string) error {
+		switch s {
+		case "bytes", "runes":
+			g.ColumnRunes = s == "runes"
+			return nil
+		}
+		return fmt.Errorf("invalid column unit %q: want bytes or runes", s)
+	})
+	flag.IntVar(&g.MaxColumns, "max-columns", 0, "print at most `n` bytes of each line")
+	flag.BoolFunc("stats", "print statistics about the search", func(string) error {
+		g.Stats = NewStats()
+		return nil
+	})
 }
 
 func (g *Grep) AddVFlag() {
@@ -408,6 +692,7 @@ func (g *Grep) File(name string) {
 	f, err := os.Open(name)
 	if err != nil {
 		fmt.Fprintf(g.Stderr, "%s\n", g.esc(err.Error()))
+		g.Missing++
 		return
 	}
 	defer f.Close()
@@ -429,57 +714,205 @@ func countNL(b []byte) int {
 	return n
 }
 
+// ErrMaxBytes is the Incomplete error recorded when a search
+// stops because it has read MaxBytes bytes.
+var ErrMaxBytes = errors.New("byte limit reached")
+
+// Reader searches the content read from r, reporting matches as being
+// in the file with the given name.
 func (g *Grep) Reader(r io.Reader, name string) {
+	g.ReaderContext(context.Background(), r, name)
+}
+
+// ReaderContext is like Reader but stops early if ctx is canceled
+// or g has read MaxBytes bytes. In that case it records the reason
+// in g.Incomplete and returns it.
+func (g *Grep) ReaderContext(ctx context.Context, r io.Reader, name string) error {
+	g.Files++
+	defer func(n int) {
+		if g.Matches > n {
+			g.FilesMatched++
+		}
+	}(g.Matches)
+	r = charset.NewReader(r, g.Encodings.Lookup(name))
+	if re := g.Regexp; re.fuzzy == nil {
+		g.mc = re.get()
+		defer func() {
+			re.put(g.mc)
+			g.mc = nil
+		}()
+	}
+	if g.JSON && g.OnLines == nil {
+		g.jsonStart()
+		defer func(n int64, start time.Time) {
+			g.jsonEnd(name, g.Bytes-n, start)
+		}(g.Bytes, time.Now())
+	}
+	if g.Regexp.multi {
+		return g.readerMulti(ctx, r, name)
+	}
 	if g.buf == nil {
 		g.buf = make([]byte, 1<<20)
 	}
 	var (
 		buf        = g.buf[:0]
-		needLineno = g.N || g.HTML
+		needLineno = g.N || g.HTML || g.events() || g.Column || g.Vimgrep || g.OnMatch != nil || g.PreContext+g.PostContext > 0
 		lineno     = 1
-		count      = 0
+		count      = 0 // matches in this file
 		prefix     = ""
+		ctxPrefix  = "" // prefix for lines of context
 		beginText  = true
 		endText    = false
+		textStart  = true // buf starts at beginning of text
+
+		// JSON and grep-style context output print context lines
+		// one at a time, each at most once.
+		context    = g.events() || g.PreContext+g.PostContext > 0 && !g.Compact && !g.O && !g.Vimgrep
+		base       int64 // offset in the text of buf[0]
+		printed    int64 // offset in the text after the last line printed
+		printedN   = 1   // line number at printed
+		printedAny = false
+		after      = 0 // lines of context after the last match still to print
 	)
 	if !g.H {
-		prefix = name + ":"
+		prefix = g.Color.path(g.displayName(name)) + g.Color.sep(":")
+		ctxPrefix = g.Color.path(g.displayName(name)) + g.Color.sep("-")
+	}
+	// startGroup prints the -- separating groups of lines
+	// if the line at offset off does not follow the last line printed.
+	startGroup := func(off int64) {
+		if g.events() {
+			return
+		}
+		if g.grouped && (off > printed || !printedAny) {
+			fmt.Fprintf(g.Stdout, "%s\n", g.Color.sep("--"))
+		}
+		g.grouped = true
+		printedAny = true
+	}
+	// printContext prints buf[p:e], which is line n, as context.
+	printContext := func(p, e, n int) {
+		if g.events() {
+			g.jsonLines("context", name, buf[p:e], base+int64(p), n, nil)
+		} else {
+			startGroup(base + int64(p))
+			g.printLine(name, ctxPrefix, "-", n, 0, buf[p:e], nil)
+		}
+		printed = base + int64(e)
+	}
+	// printAfter prints the remaining lines of context after the
+	// last match, stopping at buf[limit].
+	printAfter := func(limit int) {
+		for p := int(printed - base); after > 0 && p < limit; after-- {
+			e := limit
+			if i := bytes.IndexByte(buf[p:limit], '\n'); i >= 0 {
+				e = p + i + 1
+			}
+			printContext(p, e, printedN)
+			p = e
+			printedN++
+		}
+	}
+	// printBefore prints the lines of context before the match
+	// at buf[lineStart], which is on line lineno,
+	// skipping lines already printed.
+	printBefore := func(lineStart, lineno int) {
+		p := lineStart - lineSuffixLen(buf[:lineStart], g.PreContext)
+		p = max(p, int(printed-base))
+		n := lineno - countNL(buf[p:lineStart])
+		for p < lineStart {
+			e := p + bytes.IndexByte(buf[p:lineStart], '\n') + 1
+			printContext(p, e, n)
+			p = e
+			n++
+		}
 	}
 	chunkStart := 0
+	var (
+		stop    error
+		limited = false // stopped because of Limit or FileLimit
+	)
+Scan:
 	for {
-		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		if stop = ctx.Err(); stop != nil {
+			break
+		}
+		limit := cap(buf)
+		if g.MaxBytes > 0 {
+			if g.Bytes >= g.MaxBytes {
+				stop = ErrMaxBytes
+				break
+			}
+			limit = int(min(int64(limit), int64(len(buf))+g.MaxBytes-g.Bytes))
+		}
+		n, err := io.ReadFull(r, buf[len(buf):limit])
+		g.Bytes += int64(n)
 		buf = buf[:len(buf)+n]
 		end := len(buf)
-		if err == nil {
+		switch {
+		case err != nil:
+			endText = true
+		case g.MaxBytes > 0 && g.Bytes >= g.MaxBytes:
+			// This is the last read; scan all of it.
+		default:
 			// Stop scan before trailing fragment of a line;
 			// also stop before g.PostContext whole lines,
 			// so we know we'll have the context we need to print.
-			d := lineSuffixLen(buf, g.PostContext+1)
-			if d < len(buf) {
-				end = len(buf) - d
+			end = len(buf) - lineSuffixLen(buf, g.PostContext+1)
+			if end <= chunkStart {
+				// A line, or a line and its context, does not fit
+				// in the buffer. Grow it and read more, rather than
+				// scanning part of a line and splitting a match.
+				buf = slices.Grow(buf, cap(buf))
+				continue
 			}
-		} else {
-			endText = true
 		}
 		for chunkStart < end {
-			m1 := g.Regexp.Match(buf[chunkStart:end], beginText, endText) + chunkStart
+			// Without a prefilter, run the DFA over the rest of the chunk.
+			// With one, skip to the next line that might match
+			// and run the DFA over just that line.
+			scanEnd, scanEndText := end, endText
+			if pre := g.Regexp.pre; pre != nil {
+				i := pre.index(buf[chunkStart:end])
+				if i < 0 {
+					break
+				}
+				i += chunkStart
+				lineStart := bytes.LastIndexByte(buf[chunkStart:i], '\n') + 1 + chunkStart
+				if lineStart > chunkStart {
+					if needLineno {
+						lineno += countNL(buf[chunkStart:lineStart])
+					}
+					chunkStart = lineStart
+					beginText = false
+				}
+				if j := bytes.IndexByte(buf[i:end], '\n'); j >= 0 {
+					scanEnd, scanEndText = i+j+1, false
+				}
+			}
+			m1 := g.Regexp.match(g.mc, buf[chunkStart:scanEnd], beginText, scanEndText) + chunkStart
 			beginText = false
 			if m1 < chunkStart {
+				if scanEnd < end {
+					// Not a match after all; try the next line.
+					if needLineno {
+						lineno++
+					}
+					chunkStart = scanEnd
+					continue
+				}
 				break
 			}
 			g.Match = true
-			if g.Limit > 0 && g.Matches >= g.Limit {
-				g.Limited = true
-				return
+			if g.limit(count) {
+				limited = true
+				break Scan
 			}
 			g.Matches++
+			count++
 			if g.L {
-				if g.HTML {
-					fmt.Fprintf(g.Stdout, "<a href=\"show/%s\">%s</a>\n", g.esc(name), g.esc(name))
-				} else {
-					fmt.Fprintf(g.Stdout, "%s\n", name)
-				}
-				return
+				g.printName(name)
+				return nil
 			}
 			lineStart := bytes.LastIndex(buf[chunkStart:m1], nl) + 1 + chunkStart
 			lineEnd := m1 + 1
@@ -490,29 +923,53 @@ func (g *Grep) Reader(r io.Reader, name string) {
 				lineno += countNL(buf[chunkStart:lineStart])
 			}
 			line := buf[lineStart:lineEnd]
-			nl := ""
-			if len(line) == 0 || line[len(line)-1] != '\n' {
-				nl = "\n"
+			if g.OnMatch != nil {
+				g.OnMatch(name, lineno, chomp1(line))
 			}
 			switch {
 			case g.C:
-				count++
+				// Counted above.
+			case context:
+				printAfter(lineStart)
+				printBefore(lineStart, lineno)
+				var sub [][]int
+				if g.events() || g.Color != nil || g.Column || g.MaxColumns > 0 {
+					sub = g.Regexp.findLine(g.mc, chomp1(line), lineStart == 0 && textStart, lineEnd == end && endText)
+				}
+				if g.events() {
+					g.jsonLines("match", name, line, base+int64(lineStart), lineno, sub)
+				} else {
+					startGroup(base + int64(lineStart))
+					g.printLine(name, prefix, ":", lineno, g.column(line, sub), line, sub)
+				}
+				printed, printedN = base+int64(lineEnd), lineno+1
+				printedAny = true
+				after = g.PostContext
+			case g.O:
+				g.printMatches(name, prefix, lineno, chomp1(line), lineStart == 0 && textStart, lineEnd == end && endText)
+			case g.Vimgrep:
+				sub := g.Regexp.findLine(g.mc, chomp1(line), lineStart == 0 && textStart, lineEnd == end && endText)
+				g.printVimgrep(name, prefix, lineno, line, sub)
 			case g.PreContext+g.PostContext > 0:
-				fmt.Fprintf(g.Stdout, "%s%d:\n", prefix, lineno)
+				fmt.Fprintf(g.Stdout, "%s%s%s\n", prefix, g.Color.line(lineno), g.Color.sep(":"))
 				before, match, after := lineContext(g.PreContext, g.PostContext, buf, lineStart, lineEnd)
 				for _, line := range before {
-					fmt.Fprintf(g.Stdout, "\t\t%s\n", line)
+					fmt.Fprintf(g.Stdout, "\t\t%s\n", g.lineText(line, nil))
 				}
-				fmt.Fprintf(g.Stdout, "\t>>\t%s\n", match)
+				var sub [][]int
+				if g.Color != nil || g.MaxColumns > 0 {
+					sub = g.Regexp.findLine(g.mc, match, lineStart == 0 && textStart, lineEnd == end && endText)
+				}
+				fmt.Fprintf(g.Stdout, "\t>>\t%s\n", g.lineText(match, sub))
 				for _, line := range after {
-					fmt.Fprintf(g.Stdout, "\t\t%s\n", line)
+					fmt.Fprintf(g.Stdout, "\t\t%s\n", g.lineText(line, nil))
 				}
-			case g.HTML:
-				fmt.Fprintf(g.Stdout, "<a href=\"/show/%s?q=%s#L%d\">%s:%d</a>:%s%s", g.esc(strings.ReplaceAll(name, "#", ">")), g.esc(g.Regexp.String()), lineno, g.esc(name), lineno, g.esc(string(line)), nl)
-			case g.N:
-				fmt.Fprintf(g.Stdout, "%s%d:%s%s", prefix, lineno, line, nl)
 			default:
-				fmt.Fprintf(g.Stdout, "%s%s%s", prefix, line, nl)
+				var sub [][]int
+				if g.Color != nil || g.Column || g.MaxColumns > 0 {
+					sub = g.Regexp.findLine(g.mc, chomp1(line), lineStart == 0 && textStart, lineEnd == end && endText)
+				}
+				g.printLine(name, prefix, ":", lineno, g.column(line, sub), line, sub)
 			}
 			if needLineno {
 				lineno++
@@ -522,15 +979,17 @@ func (g *Grep) Reader(r io.Reader, name string) {
 		if needLineno && err == nil {
 			lineno += countNL(buf[chunkStart:end])
 		}
+		if context {
+			printAfter(end)
+		}
 		// Slide pre-context and unprocessed bytes down to start of buffer.
+		// If they fill it, the next read grows it.
 		d := lineSuffixLen(buf[:end], g.PreContext)
-		if d == end {
-			// Not enough room; give up on context.
-			d = 0
-		}
 		n = copy(buf, buf[end-d:])
 		buf = buf[:n]
+		base += int64(end - d)
 		chunkStart = d
+		textStart = false
 		if endText && err != nil {
 			if err != io.EOF && err != io.ErrUnexpectedEOF {
 				fmt.Fprintf(g.Stderr, "%s: %v\n", g.esc(name), err)
@@ -538,13 +997,451 @@ func (g *Grep) Reader(r io.Reader, name string) {
 			break
 		}
 	}
+	if limited && context {
+		// Print the context after the last match, as grep -m does.
+		printAfter(len(buf))
+	}
 	if g.C && count > 0 {
-		if g.HTML {
-			fmt.Fprintf(g.Stdout, "<a href=\"show/%s?q=%s\">%s</a>: %d\n", g.esc(name), g.esc(g.Regexp.String()), g.esc(name), count)
-		} else {
-			fmt.Fprintf(g.Stdout, "%s: %d\n", name, count)
+		g.printCount(name, count)
+	}
+	if stop != nil && g.Incomplete == nil {
+		g.Incomplete = stop
+	}
+	return stop
+}
+
+// Finish reports, after the last file has been searched,
+// whether the results are incomplete because of a limit or cancellation,
+// and how many files FileLimit cut short.
+// The notes go to Stderr, except in HTML mode, where they are part of the page.
+// In JSON mode, Finish also writes the summary event; the files cut short
+// are already marked as limited in their end events.
+func (g *Grep) Finish() {
+	var msg string
+	if why := g.Stopped(); why != "" {
+		msg = "results incomplete: " + why
+	}
+	var note string
+	if g.FilesLimited > 0 {
+		note = fmt.Sprintf("stopped reading %d %s after %d %s", g.FilesLimited, plural(g.FilesLimited, "file", "files"),
+			g.FileLimit, plural(g.FileLimit, "match", "matches"))
+	}
+	for _, m := range []string{note, msg} {
+		switch {
+		case m == "":
+		case g.HTML:
+			fmt.Fprintf(g.Stdout, "<p class=\"incomplete\">%s</p>\n", g.esc(m))
+		default:
+			fmt.Fprintf(g.Stderr, "%s\n", m)
 		}
 	}
+	if g.Stats != nil {
+		g.Stats.finish(g)
+		g.Stats.print(g.Stderr)
+	}
+	if g.JSON && g.OnLines == nil {
+		g.jsonSummary(msg)
+	}
+}
+
+// Stopped returns why the search stopped before finding all the results,
+// either g.Incomplete or reaching g.Limit, or "" if it did not.
+func (g *Grep) Stopped() string {
+	switch {
+	case g.Incomplete != nil:
+		return g.Incomplete.Error()
+	case g.Limited:
+		return fmt.Sprintf("stopped after %d %s", g.Limit, plural(g.Limit, "match", "matches"))
+	}
+	return ""
+}
+
+// limit reports whether the search must stop instead of counting
+// another match, after finding n matches in the current file.
+// It records which limit stopped it.
+func (g *Grep) limit(n int) bool {
+	switch {
+	case g.Limit > 0 && g.Matches >= g.Limit:
+		g.Limited = true
+	case g.FileLimit > 0 && n >= g.FileLimit:
+		g.FilesLimited++
+		g.json.limited = true
+	default:
+		return false
+	}
+	return true
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// printLine prints a line, which may or may not end in a newline.
+// The sep, : for a matching line or - for context, follows the line number
+// and, if col > 0, the column.
+// With color, the spans in sub, which are byte offsets in line,
+// are highlighted as matches.
+func (g *Grep) printLine(name, prefix, sep string, lineno, col int, line []byte, sub [][]int) {
+	text := g.lineText(line, sub)
+	switch {
+	case g.HTML:
+		fmt.Fprintf(g.Stdout, "<a href=\"%s\">%s:%d</a>%s%s\n", g.showURL(name, lineno), g.esc(name), lineno, sep, text)
+	case col > 0:
+		fmt.Fprintf(g.Stdout, "%s%s%s%d%s%s\n", prefix, g.Color.line(lineno), g.Color.sep(sep), col, g.Color.sep(sep), text)
+	case g.N || g.Column || g.Vimgrep:
+		fmt.Fprintf(g.Stdout, "%s%s%s%s\n", prefix, g.Color.line(lineno), g.Color.sep(sep), text)
+	default:
+		fmt.Fprintf(g.Stdout, "%s%s\n", prefix, text)
+	}
+}
+
+// lineText returns line, without its newline, as printed:
+// escaped for HTML, with the spans in sub highlighted,
+// and cut down to g.MaxColumns bytes.
+func (g *Grep) lineText(line []byte, sub [][]int) string {
+	line = chomp1(line)
+	cut1, cut2 := 0, 0
+	if g.MaxColumns > 0 && len(line) > g.MaxColumns {
+		line, sub, cut1, cut2 = clip(line, sub, g.MaxColumns)
+	}
+	var text string
+	if g.HTML {
+		text = g.esc(string(line))
+	} else {
+		text = g.Color.highlight(line, sub)
+	}
+	if cut1 > 0 {
+		text = fmt.Sprintf("[%d bytes omitted] %s", cut1, text)
+	}
+	if cut2 > 0 {
+		text = fmt.Sprintf("%s [%d bytes omitted]", text, cut2)
+	}
+	return text
+}
+
+// clip returns at most n bytes of line, on rune boundaries,
+// along with the spans in sub adjusted to the shorter line and
+// the numbers of bytes cut from the start and end of line.
+// If the first span in sub ends beyond the first n bytes,
+// the part returned begins shortly before that span.
+func clip(line []byte, sub [][]int, n int) (out []byte, outSub [][]int, cut1, cut2 int) {
+	start := 0
+	if len(sub) > 0 && sub[0][1] > n {
+		start = max(min(sub[0][0]-n/4, len(line)-n), 0)
+		for start > 0 && !utf8.RuneStart(line[start]) {
+			start--
+		}
+	}
+	end := min(start+n, len(line))
+	for end < len(line) && end > start && !utf8.RuneStart(line[end]) {
+		end--
+	}
+	for _, m := range sub {
+		if m[1] < start || m[0] > end {
+			continue
+		}
+		outSub = append(outSub, []int{max(m[0], start) - start, min(m[1], end) - start})
+	}
+	return line[start:end], outSub, start, len(line) - end
+}
+
+// column returns the column to print for a line with matches sub,
+// or 0 if columns are not being printed.
+// A line with no known matches, as from CompileFuzzy, is given column 1.
+func (g *Grep) column(line []byte, sub [][]int) int {
+	if !g.Column && !g.Vimgrep {
+		return 0
+	}
+	if len(sub) == 0 {
+		return 1
+	}
+	if g.ColumnRunes {
+		return utf8.RuneCount(line[:sub[0][0]]) + 1
+	}
+	return sub[0][0] + 1
+}
+
+// printVimgrep prints line once for each non-empty match in sub,
+// with the column of that match.
+func (g *Grep) printVimgrep(name, prefix string, lineno int, line []byte, sub [][]int) {
+	printed := false
+	for i, m := range sub {
+		if m[0] < m[1] {
+			g.printLine(name, prefix, ":", lineno, g.column(line, sub[i:]), line, sub[i:i+1])
+			printed = true
+		}
+	}
+	if !printed {
+		g.printLine(name, prefix, ":", lineno, 1, line, nil)
+	}
+}
+
+// displayName returns the named file's name as printed in text output:
+// relative to g.Dir if the file is in it.
+func (g *Grep) displayName(name string) string {
+	if g.Dir == "" {
+		return name
+	}
+	rel, err := filepath.Rel(g.Dir, name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return name
+	}
+	return rel
+}
+
+// readerMulti is ReaderContext for a Regexp compiled by CompileMultiline.
+// Since a match can span any number of lines, it reads the whole input
+// before searching. It prints every line of each match,
+// or with the O flag, the text of each match.
+// It ignores PreContext and PostContext.
+func (g *Grep) readerMulti(ctx context.Context, r io.Reader, name string) error {
+	data := g.buf[:0]
+	var stop error
+	for {
+		if stop = ctx.Err(); stop != nil {
+			break
+		}
+		if g.MaxBytes > 0 && g.Bytes >= g.MaxBytes {
+			stop = ErrMaxBytes
+			break
+		}
+		if len(data) == cap(data) {
+			data = append(data, 0)[:len(data)]
+		}
+		limit := cap(data)
+		if g.MaxBytes > 0 {
+			limit = int(min(int64(limit), int64(len(data))+g.MaxBytes-g.Bytes))
+		}
+		n, err := r.Read(data[len(data):limit])
+		data = data[:len(data)+n]
+		g.Bytes += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(g.Stderr, "%s: %v\n", g.esc(name), err)
+			break
+		}
+	}
+	g.buf = data[:0]
+	if stop != nil {
+		if g.Incomplete == nil {
+			g.Incomplete = stop
+		}
+		return stop
+	}
+	if pre := g.Regexp.pre; pre != nil && pre.index(data) < 0 {
+		return nil
+	}
+
+	prefix := ""
+	if !g.H {
+		prefix = g.Color.path(g.displayName(name)) + g.Color.sep(":")
+	}
+	var (
+		count   = 0
+		lineno  = 1
+		counted = 0 // lineno is the line number at data[counted]
+		printed = 0 // lines before data[printed] have been printed
+
+		// In JSON mode, matches sharing lines are reported together.
+		group                [][]int
+		groupStart, groupEnd int
+		groupLineno          int
+	)
+	flush := func() {
+		if group == nil {
+			return
+		}
+		for _, m := range group {
+			m[0] -= groupStart
+			m[1] -= groupStart
+		}
+		g.jsonLines("match", name, data[groupStart:groupEnd], int64(groupStart), groupLineno, group)
+		group = nil
+	}
+	defer flush()
+	all := g.Regexp.findAll(g.mc, data, -1)
+	for k, m := range all {
+		if m[0] == m[1] {
+			continue
+		}
+		g.Match = true
+		if g.limit(count) {
+			break
+		}
+		g.Matches++
+		count++
+		if g.L {
+			g.printName(name)
+			return nil
+		}
+		start := bytes.LastIndexByte(data[:m[0]], '\n') + 1
+		lineno += countNL(data[counted:start])
+		counted = start
+		if g.OnMatch != nil {
+			line := data[start:]
+			if i := bytes.IndexByte(line, '\n'); i >= 0 {
+				line = line[:i]
+			}
+			g.OnMatch(name, lineno, line)
+		}
+		if g.C {
+			continue
+		}
+		end := m[1]
+		if data[end-1] != '\n' {
+			if i := bytes.IndexByte(data[end:], '\n'); i >= 0 {
+				end += i + 1
+			} else {
+				end = len(data)
+			}
+		}
+		if g.events() {
+			if group != nil && start < groupEnd {
+				groupEnd = max(groupEnd, end)
+			} else {
+				flush()
+				groupStart, groupEnd, groupLineno = start, end, lineno
+			}
+			group = append(group, m)
+			continue
+		}
+		if g.O {
+			fmt.Fprintf(g.Stdout, "%s", prefix)
+			if g.N || g.Column || g.Vimgrep {
+				fmt.Fprintf(g.Stdout, "%s%s", g.Color.line(lineno), g.Color.sep(":"))
+			}
+			if col := g.column(data[start:], [][]int{{m[0] - start, m[1] - start}}); col > 0 {
+				fmt.Fprintf(g.Stdout, "%d%s", col, g.Color.sep(":"))
+			}
+			fmt.Fprintf(g.Stdout, "%s\n", g.Color.match(string(chomp1(data[m[0]:m[1]]))))
+			continue
+		}
+		// Print the lines containing the match,
+		// skipping any already printed for the previous match.
+		from := max(start, printed)
+		n := lineno + countNL(data[start:from])
+		for _, line := range bytes.SplitAfter(data[from:end], nl) {
+			if len(line) == 0 {
+				continue
+			}
+			var sub [][]int
+			if g.Color != nil || g.Column || g.Vimgrep || g.MaxColumns > 0 {
+				sub = spansIn(all[k:], from, from+len(line))
+			}
+			g.printLine(name, prefix, ":", n, g.column(line, sub), line, sub)
+			from += len(line)
+			n++
+		}
+		printed = end
+	}
+	if g.C && count > 0 {
+		g.printCount(name, count)
+	}
+	return nil
+}
+
+// spansIn returns the parts of the sorted matches that fall
+// in data[lo:hi], as offsets from lo.
+func spansIn(matches [][]int, lo, hi int) [][]int {
+	var sub [][]int
+	for _, m := range matches {
+		if m[0] >= hi {
+			break
+		}
+		if m[1] > lo && m[0] < m[1] {
+			sub = append(sub, []int{max(m[0], lo) - lo, min(m[1], hi) - lo})
+		}
+	}
+	return sub
+}
+
+// printMatches prints the non-empty matches in line, one per line,
+// for the O flag. The begin and end flags report whether line is
+// at the beginning and end of the text.
+func (g *Grep) printMatches(name, prefix string, lineno int, line []byte, begin, end bool) {
+	for _, m := range g.Regexp.findLine(g.mc, line, begin, end) {
+		text := line[m[0]:m[1]]
+		if len(text) == 0 {
+			continue
+		}
+		switch col := g.column(line, [][]int{m}); {
+		case g.HTML:
+			fmt.Fprintf(g.Stdout, "<a href=\"%s\">%s:%d</a>:%s\n", g.showURL(name, lineno), g.esc(name), lineno, g.esc(string(text)))
+		case col > 0:
+			fmt.Fprintf(g.Stdout, "%s%s%s%d%s%s\n", prefix, g.Color.line(lineno), g.Color.sep(":"), col, g.Color.sep(":"), g.Color.match(string(text)))
+		case g.N:
+			fmt.Fprintf(g.Stdout, "%s%s%s%s\n", prefix, g.Color.line(lineno), g.Color.sep(":"), g.Color.match(string(text)))
+		default:
+			fmt.Fprintf(g.Stdout, "%s%s\n", prefix, g.Color.match(string(text)))
+		}
+	}
+}
+
+// List records a match for the named file without reading it
+// and prints the name, as the L flag would, or with the C flag
+// a count of 1. In JSON mode it writes begin and end events for it,
+// and if OnLines is set it reports a Lines with Lineno 0.
+// It is used for searches that match files by name alone.
+func (g *Grep) List(name string) {
+	g.Match = true
+	if g.limit(0) {
+		return
+	}
+	g.Matches++
+	g.FilesMatched++
+	if g.OnLines != nil && !g.C && !g.L {
+		g.OnLines(&Lines{Name: name})
+		return
+	}
+	if g.JSON {
+		// The file has no lines to report, only begin and end events.
+		g.jsonStart()
+		g.json.began = true
+		g.writeJSON("begin", jsonBegin{jsonText(name)})
+		g.jsonEnd(name, 0, time.Now())
+		return
+	}
+	if g.C {
+		g.printCount(name, 1)
+		return
+	}
+	g.printName(name)
+}
+
+// showURL returns the HTML-escaped URL of the csweb page showing
+// the named file with the matches of g.Regexp highlighted,
+// scrolled to line lineno if it is positive.
+func (g *Grep) showURL(name string, lineno int) string {
+	u := url.URL{Path: "/show/" + strings.TrimPrefix(filepath.ToSlash(name), "/")}
+	if g.Regexp != nil {
+		u.RawQuery = url.Values{"q": {g.Regexp.String()}}.Encode()
+	}
+	if lineno > 0 {
+		u.Fragment = fmt.Sprintf("L%d", lineno)
+	}
+	return html.EscapeString(u.String())
+}
+
+// printCount prints the count of matches in the named file, for -c.
+func (g *Grep) printCount(name string, count int) {
+	if g.HTML {
+		fmt.Fprintf(g.Stdout, "<a href=\"%s\">%s</a>: %d\n", g.showURL(name, 0), g.esc(name), count)
+	} else {
+		fmt.Fprintf(g.Stdout, "%s%s %d\n", g.Color.path(g.displayName(name)), g.Color.sep(":"), count)
+	}
+}
+
+func (g *Grep) printName(name string) {
+	if g.HTML {
+		fmt.Fprintf(g.Stdout, "<a href=\"%s\">%s</a>\n", g.showURL(name, 0), g.esc(name))
+	} else {
+		fmt.Fprintf(g.Stdout, "%s\n", g.Color.path(g.displayName(name)))
+	}
 }
 
 func lineSuffixLen(buf []byte, n int) int {
@@ -639,6 +1536,14 @@ func cutPrefix(line, prefix []byte) []byte {
 	return line[len(prefix):]
 }
 
+// chomp1 returns s without its trailing newline, if any.
+func chomp1(s []byte) []byte {
+	if len(s) > 0 && s[len(s)-1] == '\n' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
 func chomp(s []byte) []byte {
 	i := len(s)
 	for i > 0 && (s[i-1] == ' ' || s[i-1] == '\t' || s[i-1] == '\r' || s[i-1] == '\n') {
@@ -0,0 +1,195 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Running the DFA costs a table lookup per byte of input,
+// but most lines of a candidate file do not match.
+// A prefilter finds lines that might match much faster,
+// using bytes.Index to look for a literal that every match must contain
+// or, failing that, bytes.IndexByte to look for the one byte that
+// every match must begin with. Grep runs the DFA only on the lines
+// the prefilter finds.
+
+// maxFirst is the largest set of first bytes worth scanning for.
+// Scanning for two bytes with bytes.IndexAny, as for (?i)mutex,
+// made searching the Go tree 40% slower than running the DFA.
+const maxFirst = 1
+
+// A prefilter describes the bytes that every match must contain.
+type prefilter struct {
+	lit   []byte // literal every match contains
+	first byte   // byte every match begins with, if lit is nil
+}
+
+// newPrefilter returns the prefilter for re,
+// or nil if re can match without any particular bytes.
+// Unless multi is set, a match cannot contain a newline,
+// so literals containing one are of no use.
+func newPrefilter(re *syntax.Regexp, multi bool) *prefilter {
+	if lit := requiredLiteral(re); len(lit) > 0 && (multi || bytes.IndexByte(lit, '\n') < 0) {
+		return &prefilter{lit: lit}
+	}
+	if first, nullable := firstBytes(re); len(first) == 1 && !nullable {
+		return &prefilter{first: first[0]}
+	}
+	return nil
+}
+
+// index returns the index of the first possible match in b,
+// or -1 if b cannot contain a match.
+// The match, if there is one, is on the line containing b[index].
+func (p *prefilter) index(b []byte) int {
+	if p.lit != nil {
+		return bytes.Index(b, p.lit)
+	}
+	return bytes.IndexByte(b, p.first)
+}
+
+// requiredLiteral returns the longest literal that every match of re
+// must contain, or nil if there is none.
+// Case-folded literals match more than one string and are ignored.
+func requiredLiteral(re *syntax.Regexp) []byte {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []byte(string(re.Rune))
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent literals form a longer literal.
+		var best, cur []byte
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+				cur = append(cur, string(sub.Rune)...)
+				continue
+			}
+			if len(cur) > len(best) {
+				best = cur
+			}
+			cur = nil
+			if lit := requiredLiteral(sub); len(lit) > len(best) {
+				best = lit
+			}
+		}
+		if len(cur) > len(best) {
+			best = cur
+		}
+		return best
+	}
+	return nil
+}
+
+// firstBytes returns the set of bytes that can begin a match of re
+// and whether re can match the empty string.
+// If the set would have more than maxFirst bytes, firstBytes returns nil.
+func firstBytes(re *syntax.Regexp) (first []byte, nullable bool) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return []byte{}, false
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return []byte{}, true
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return []byte{}, true
+		}
+		r0 := re.Rune[0]
+		first = addFirst(first, r0)
+		if re.Flags&syntax.FoldCase != 0 {
+			for r := unicode.SimpleFold(r0); r != r0; r = unicode.SimpleFold(r) {
+				first = addFirst(first, r)
+			}
+		}
+		return limitFirst(first), false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			// UTF-8 leading bytes increase with the rune,
+			// so lo and hi bound the leading bytes in between.
+			var blo, bhi [utf8.UTFMax]byte
+			utf8.EncodeRune(blo[:], lo)
+			utf8.EncodeRune(bhi[:], hi)
+			for c := int(blo[0]); c <= int(bhi[0]); c++ {
+				first = addByte(first, byte(c))
+				if len(first) > maxFirst {
+					return nil, false
+				}
+			}
+		}
+		return limitFirst(first), false
+	case syntax.OpCapture, syntax.OpPlus:
+		return firstBytes(re.Sub[0])
+	case syntax.OpStar, syntax.OpQuest:
+		first, _ = firstBytes(re.Sub[0])
+		return first, true
+	case syntax.OpRepeat:
+		first, nullable = firstBytes(re.Sub[0])
+		return first, nullable || re.Min == 0
+	case syntax.OpConcat:
+		first = []byte{}
+		for _, sub := range re.Sub {
+			f, n := firstBytes(sub)
+			if f == nil {
+				return nil, false
+			}
+			for _, c := range f {
+				first = addByte(first, c)
+			}
+			if !n {
+				return limitFirst(first), false
+			}
+		}
+		return limitFirst(first), true
+	case syntax.OpAlternate:
+		first = []byte{}
+		for _, sub := range re.Sub {
+			f, n := firstBytes(sub)
+			if f == nil {
+				return nil, false
+			}
+			for _, c := range f {
+				first = addByte(first, c)
+			}
+			nullable = nullable || n
+		}
+		return limitFirst(first), nullable
+	}
+	return nil, false
+}
+
+// addFirst adds the first byte of the UTF-8 encoding of r to first.
+func addFirst(first []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	utf8.EncodeRune(buf[:], r)
+	return addByte(first, buf[0])
+}
+
+func addByte(first []byte, c byte) []byte {
+	if bytes.IndexByte(first, c) >= 0 {
+		return first
+	}
+	return append(first, c)
+}
+
+func limitFirst(first []byte) []byte {
+	if len(first) > maxFirst {
+		return nil
+	}
+	return first
+}
@@ -6,18 +6,55 @@
 // use in grep-like programs.
 package regexp
 
-import "regexp/syntax"
+import (
+	stdregexp "regexp"
+	"regexp/syntax"
+	"sync"
+	"sync/atomic"
+)
 
 func bug() {
 	panic("codesearch/regexp: internal error")
 }
 
 // Regexp is the representation of a compiled regular expression.
-// A Regexp is NOT SAFE for concurrent use by multiple goroutines.
+// A Regexp is safe for concurrent use by multiple goroutines.
+// Each goroutine matching at the same time uses its own lazily
+// built DFA, so the first matches in a new goroutine are slower.
 type Regexp struct {
 	Syntax *syntax.Regexp
 	expr   string // original expression
-	m      matcher
+	prog   *syntax.Prog
+	multi  bool         // matches can span lines
+	pre    *prefilter   // finds possible matches; nil if none
+	fuzzy  *fuzzy       // approximate matcher, for CompileFuzzy
+	limit  atomic.Int64 // cache limit for machines
+
+	// Machines not in use. Unlike a sync.Pool, the list keeps its
+	// machines, and so their DFA states, across garbage collections.
+	mu   sync.Mutex
+	free []*machine
+
+	// The standard library's version of the Regexp, for Replacements.
+	stdOnce sync.Once
+	std     *stdregexp.Regexp
+	stdErr  error
+
+	// Cache statistics, collected as machines are released.
+	created atomic.Int64
+	flushes atomic.Int64
+	nfa     atomic.Bool
+	states  atomic.Int64 // states in last released machine
+	bytes   atomic.Int64 // bytes in last released machine
+}
+
+// A machine holds the matching state for one goroutine.
+type machine struct {
+	m       matcher
+	f       finder
+	limit   int   // cache limit last applied to m
+	created int64 // m.created already counted in Regexp
+	flushes int64 // m.flushes already counted in Regexp
 }
 
 // String returns the source text used to compile the regular expression.
@@ -28,6 +65,19 @@ func (re *Regexp) String() string {
 // Compile parses a regular expression and returns, if successful,
 // a Regexp object that can be used to match against lines of text.
 func Compile(expr string) (*Regexp, error) {
+	return compile(expr, false)
+}
+
+// CompileMultiline is like Compile, but the Regexp it returns
+// can match text spanning multiple lines: \n in the expression
+// matches a newline, and so do . in (?s) mode and classes like \s and [^a].
+// For such a Regexp, Match returns the end of the first match it finds,
+// not the end of the line containing it.
+func CompileMultiline(expr string) (*Regexp, error) {
+	return compile(expr, true)
+}
+
+func compile(expr string, multi bool) (*Regexp, error) {
 	re, err := syntax.Parse(expr, syntax.Perl)
 	if err != nil {
 		return nil, err
@@ -37,23 +87,134 @@ func Compile(expr string) (*Regexp, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := toByteProg(prog); err != nil {
+	if err := toByteProg(prog, multi); err != nil {
 		return nil, err
 	}
 	r := &Regexp{
 		Syntax: re,
 		expr:   expr,
+		prog:   prog,
+		multi:  multi,
+		pre:    newPrefilter(re, multi),
 	}
-	if err := r.m.init(prog); err != nil {
+	r.limit.Store(DefaultCacheLimit)
+	// Build the first machine now, to report any errors.
+	mc, err := r.newMachine()
+	if err != nil {
 		return nil, err
 	}
+	r.free = append(r.free, mc)
 	return r, nil
 }
 
+// Multiline reports whether r was compiled by CompileMultiline.
+func (r *Regexp) Multiline() bool {
+	return r.multi
+}
+
+func (r *Regexp) newMachine() (*machine, error) {
+	mc := new(machine)
+	mc.m.multi = r.multi
+	if err := mc.m.init(r.prog); err != nil {
+		return nil, err
+	}
+	mc.limit = DefaultCacheLimit
+	return mc, nil
+}
+
+// get returns a machine for use by the calling goroutine.
+// Callers that match many times, like Grep reading a file,
+// should hold on to it instead of calling get for each match.
+func (r *Regexp) get() *machine {
+	var mc *machine
+	r.mu.Lock()
+	if n := len(r.free); n > 0 {
+		mc = r.free[n-1]
+		r.free = r.free[:n-1]
+	}
+	r.mu.Unlock()
+	if mc == nil {
+		var err error
+		mc, err = r.newMachine()
+		if err != nil {
+			// newMachine succeeded in Compile.
+			bug()
+		}
+	}
+	if limit := int(r.limit.Load()); mc.limit != limit {
+		mc.m.setCacheLimit(limit)
+		mc.limit = limit
+	}
+	return mc
+}
+
+// put records mc's cache statistics and makes it available for reuse.
+func (r *Regexp) put(mc *machine) {
+	st := mc.m.stats()
+	if st.Created != mc.created {
+		r.created.Add(st.Created - mc.created)
+		mc.created = st.Created
+	}
+	if st.Flushes != mc.flushes {
+		r.flushes.Add(st.Flushes - mc.flushes)
+		mc.flushes = st.Flushes
+	}
+	if st.NFA && !r.nfa.Load() {
+		r.nfa.Store(true)
+	}
+	r.states.Store(int64(st.States))
+	r.bytes.Store(int64(st.Bytes))
+	r.mu.Lock()
+	r.free = append(r.free, mc)
+	r.mu.Unlock()
+}
+
 func (r *Regexp) Match(b []byte, beginText, endText bool) (end int) {
-	return r.m.match(b, beginText, endText)
+	if r.fuzzy != nil {
+		return r.fuzzy.match(b)
+	}
+	mc := r.get()
+	defer r.put(mc)
+	return mc.m.match(b, beginText, endText)
+}
+
+// match is like Match but uses mc, a machine from r.get,
+// which is nil if r is fuzzy.
+func (r *Regexp) match(mc *machine, b []byte, beginText, endText bool) (end int) {
+	if r.fuzzy != nil {
+		return r.fuzzy.match(b)
+	}
+	return mc.m.match(b, beginText, endText)
 }
 
 func (r *Regexp) MatchString(s string, beginText, endText bool) (end int) {
-	return r.m.matchString(s, beginText, endText)
+	if r.fuzzy != nil {
+		return r.fuzzy.match([]byte(s))
+	}
+	mc := r.get()
+	defer r.put(mc)
+	return mc.m.matchString(s, beginText, endText)
+}
+
+// SetCacheLimit limits the memory used by each of r's caches of DFA states
+// to about n bytes. The default is DefaultCacheLimit.
+// There is one cache for each goroutine using r at the same time.
+// A smaller limit can slow matching, especially for regular expressions
+// with many alternatives or large Unicode character classes.
+func (r *Regexp) SetCacheLimit(n int) {
+	r.limit.Store(int64(n))
+}
+
+// CacheStats returns statistics about r's caches of DFA states.
+// Created and Flushes are totals over all caches, and NFA reports
+// whether any cache has fallen back to NFA simulation,
+// but States and Bytes describe only the most recently used cache.
+func (r *Regexp) CacheStats() CacheStats {
+	return CacheStats{
+		States:  int(r.states.Load()),
+		Bytes:   int(r.bytes.Load()),
+		Created: r.created.Load(),
+		Flushes: r.flushes.Load(),
+		NFA:     r.nfa.Load(),
+	}
 }
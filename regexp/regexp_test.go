@@ -6,9 +6,23 @@ package regexp
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
 	"reflect"
+	stdregexp "regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/google/codesearch/charset"
 )
 
 var nstateTests = []struct {
@@ -162,11 +176,78 @@ func TestMatch(t *testing.T) {
 	}
 }
 
+func TestMatchNFA(t *testing.T) {
+	for _, tt := range matchTests {
+		re, err := Compile("(?m)" + tt.re)
+		if err != nil {
+			t.Errorf("Compile(%#q): %v", tt.re, err)
+			continue
+		}
+		mc := re.get()
+		mc.m.nfa = true
+		b := []byte(tt.s)
+		lines := grepFunc(mc.m.match, b)
+		if !reflect.DeepEqual(lines, tt.m) {
+			t.Errorf("NFA grep(%#q, %q) = %v, want %v", tt.re, tt.s, lines, tt.m)
+		}
+	}
+}
+
+func TestCacheLimit(t *testing.T) {
+	// The DFA for this regexp has 2⁹ states,
+	// which will not fit in the smallest cache.
+	const pat = `(?m)(a|b)*a(a|b)(a|b)(a|b)(a|b)(a|b)(a|b)(a|b)(a|b)c$`
+	r := rand.New(rand.NewSource(1))
+	var b []byte
+	for i := range 20000 {
+		if i%100 == 99 {
+			b = append(b, "c\n"[r.Intn(2)], '\n')
+		}
+		b = append(b, "ab"[r.Intn(2)])
+	}
+
+	re, err := Compile(pat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := grep(re, b)
+	if len(want) == 0 {
+		t.Fatalf("no matches in test input")
+	}
+	if st := re.CacheStats(); st.Flushes != 0 || st.NFA || st.Bytes > DefaultCacheLimit {
+		t.Errorf("default cache: %+v, want no flushes", st)
+	}
+
+	// Use a single machine, to see its own statistics.
+	re, _ = Compile(pat)
+	re.SetCacheLimit(0)
+	mc := re.get()
+	lines := grepFunc(mc.m.match, b)
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("grep with small cache = %v, want %v", lines, want)
+	}
+	st := mc.m.stats()
+	if st.Flushes == 0 || !st.NFA || st.Bytes > minCacheStates*dstateSize {
+		t.Errorf("small cache: %+v, want flushes and NFA", st)
+	}
+	if st.Created > int64(len(b)) {
+		t.Errorf("small cache: %+v, created more states than input bytes", st)
+	}
+	re.put(mc)
+	if st := re.CacheStats(); st.Flushes == 0 || !st.NFA {
+		t.Errorf("small cache: CacheStats() = %+v, want flushes and NFA", st)
+	}
+}
+
 func grep(re *Regexp, b []byte) []int {
+	return grepFunc(re.Match, b)
+}
+
+func grepFunc(match func(b []byte, beginText, endText bool) int, b []byte) []int {
 	var m []int
 	lineno := 1
 	for {
-		i := re.Match(b, true, true)
+		i := match(b, true, true)
 		if i < 0 {
 			break
 		}
@@ -188,6 +269,9 @@ func grep(re *Regexp, b []byte) []int {
 	return m
 }
 
+// testColors makes highlighted output easier to read in tests.
+var testColors = &Colors{Path: "1", Line: "2", Match: "3"}
+
 var grepTests = []struct {
 	re  string
 	s   string
@@ -197,6 +281,35 @@ var grepTests = []struct {
 }{
 	{re: `a+`, s: "abc\ndef\nghalloo\n", out: "input:abc\ninput:ghalloo\n"},
 	{re: `x.*y`, s: "xay\nxa\ny\n", out: "input:xay\n"},
+	{re: `a+`, s: "abc\nxaaybaa\n", g: Grep{O: true}, out: "input:a\ninput:aa\ninput:aa\n"},
+	{re: `a+`, s: "abc\nxaaybaa", g: Grep{O: true, N: true, H: true}, out: "1:a\n2:aa\n2:aa\n"},
+	{re: `\w+@\w+\.com|x*`, s: "mail a@b.com, c@d.com\n", g: Grep{O: true}, out: "input:a@b.com\ninput:c@d.com\n"},
+	{re: `^\w+`, s: "ab cd\nef\n", g: Grep{O: true}, out: "input:ab\ninput:ef\n"},
+	{re: `a+|c`, s: "abac\nd\n", g: Grep{Color: testColors}, out: "\x1b[1minput\x1b[0m:\x1b[3ma\x1b[0mb\x1b[3ma\x1b[0m\x1b[3mc\x1b[0m\n"},
+	{re: `b`, s: "x\nab\n", g: Grep{Color: testColors, N: true, H: true}, out: "\x1b[2m2\x1b[0m:a\x1b[3mb\x1b[0m\n"},
+	{re: `b+`, s: "abb\n", g: Grep{Color: testColors, O: true, H: true}, out: "\x1b[3mbb\x1b[0m\n"},
+	{re: `b`, s: "abb\n", g: Grep{Color: testColors, C: true}, out: "\x1b[1minput\x1b[0m: 1\n"},
+	{re: `b`, s: "abb\n", g: Grep{Color: testColors, L: true}, out: "\x1b[1minput\x1b[0m\n"},
+	{re: `b`, s: "abb\n", g: Grep{Color: &Colors{Sep: "4"}}, out: "input\x1b[4m:\x1b[0mabb\n"},
+	{re: `x`, s: "1\n2\nx\n3\n4\n5\nx\n6\n", g: Grep{PreContext: 1, PostContext: 1, N: true}, out: "input-2-2\ninput:3:x\ninput-4-3\n--\ninput-6-5\ninput:7:x\ninput-8-6\n"},
+	{re: `x`, s: "x\n1\nx\n2\n3", g: Grep{PreContext: 1, PostContext: 1}, out: "input:x\ninput-1\ninput:x\ninput-2\n"},
+	{re: `x`, s: "x\n1\n2\nx", g: Grep{PostContext: 1, H: true}, out: "x\n1\n--\nx\n"},
+	{re: `x`, s: "a\n  b\n  x\n  c\n", g: Grep{PreContext: 1, PostContext: 1, Compact: true}, out: "input:3:\n\t\tb\n\t>>\tx\n\t\tc\n"},
+	{re: `b+`, s: "x\naabcb\n", g: Grep{Column: true}, out: "input:2:3:aabcb\n"},
+	{re: `b+`, s: "x\naabcb\n", g: Grep{Vimgrep: true}, out: "input:2:3:aabcb\ninput:2:5:aabcb\n"},
+	{re: `b`, s: "ébb\n", g: Grep{Vimgrep: true, ColumnRunes: true, H: true}, out: "1:2:ébb\n1:3:ébb\n"},
+	{re: `b+`, s: "abcbb\n", g: Grep{Vimgrep: true, O: true}, out: "input:1:2:b\ninput:1:4:bb\n"},
+	{re: `x`, s: "a\nbx\n", g: Grep{Column: true, PreContext: 1}, out: "input-1-a\ninput:2:2:bx\n"},
+	{re: `x`, s: "x\n", g: Grep{Vimgrep: true, Dir: "/src"}, out: "input:1:1:x\n"},
+	{re: `b&`, s: "ab&<\n", g: Grep{HTML: true}, out: "<a href=\"/show/input?q=%28%3Fm%29b%26#L1\">input:1</a>:ab&amp;&lt;\n"},
+	{re: `b#`, s: "b#\n", g: Grep{HTML: true, C: true}, out: "<a href=\"/show/input?q=%28%3Fm%29b%23\">input</a>: 1\n"},
+	{re: `b+`, s: "abb\n", g: Grep{HTML: true, O: true}, out: "<a href=\"/show/input?q=%28%3Fm%29b%2B#L1\">input:1</a>:bb\n"},
+	{re: `x`, s: "abcxdefghijk\n", g: Grep{MaxColumns: 8, H: true}, out: "abcxdefg [4 bytes omitted]\n"},
+	{re: `x`, s: "abcdefghijxk\n", g: Grep{MaxColumns: 8, H: true}, out: "[4 bytes omitted] efghijxk\n"},
+	{re: `x`, s: "abcdefghijklmnopxqrstuvwxyz\n", g: Grep{MaxColumns: 8, Column: true}, out: "input:1:17:[14 bytes omitted] opxqrstu [5 bytes omitted]\n"},
+	{re: `x`, s: "ééééééxéééé\n", g: Grep{MaxColumns: 5, H: true}, out: "[10 bytes omitted] éxé [6 bytes omitted]\n"},
+	{re: `x`, s: "abcdefghijxk\n", g: Grep{MaxColumns: 8, H: true, Color: testColors}, out: "[4 bytes omitted] efghij\x1b[3mx\x1b[0mk\n"},
+	{re: `x`, s: "0123456789\nx\n", g: Grep{MaxColumns: 4, PreContext: 1, H: true}, out: "0123 [6 bytes omitted]\nx\n"},
 }
 
 func TestGrep(t *testing.T) {
@@ -217,3 +330,879 @@ func TestGrep(t *testing.T) {
 		}
 	}
 }
+
+// TestGrepLongLines checks that Grep finds the same matches and context
+// when lines and their context do not fit in its buffer.
+func TestGrepLongLines(t *testing.T) {
+	re, err := Compile(`(?m)ab+c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for range 200 {
+		var b []byte
+		for range r.Intn(10) {
+			b = append(b, strings.Repeat("x", r.Intn(50))...)
+			if r.Intn(3) == 0 {
+				b = append(b, "a"+strings.Repeat("b", r.Intn(20))+"c"...)
+			}
+			b = append(b, strings.Repeat("y", r.Intn(50))+"\n"...)
+		}
+		for _, g := range []Grep{{N: true}, {PreContext: 1, N: true}, {PostContext: 2}, {PreContext: 2, PostContext: 1, Compact: true}} {
+			var want, out bytes.Buffer
+			g.Regexp = re
+			small := g
+			g.Stdout, g.Stderr = &want, &want
+			g.Reader(bytes.NewReader(b), "input")
+			small.Stdout, small.Stderr = &out, &out
+			small.buf = make([]byte, 8)
+			small.Reader(bytes.NewReader(b), "input")
+			if out.String() != want.String() {
+				t.Fatalf("grep -A %d -B %d with small buffer:\n%s\nhave:\n%s\nwant:\n%s", g.PostContext, g.PreContext, b, out.String(), want.String())
+			}
+		}
+	}
+}
+
+func TestGrepContextFiles(t *testing.T) {
+	re, err := Compile(`(?m)x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	g := Grep{Regexp: re, Stdout: &out, Stderr: &out, PostContext: 1}
+	g.Reader(strings.NewReader("x\n1\n"), "a")
+	g.Reader(strings.NewReader("y\n"), "b")
+	g.Reader(strings.NewReader("x\n"), "c")
+	if want := "a:x\na-1\n--\nc:x\n"; out.String() != want {
+		t.Errorf("grep -A 1 in several files = %q, want %q", out.String(), want)
+	}
+}
+
+func TestGrepDir(t *testing.T) {
+	re, err := Compile(`(?m)x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(string(filepath.Separator), "src")
+	var out bytes.Buffer
+	g := Grep{Regexp: re, Stdout: &out, Stderr: &out, Dir: dir}
+	for _, name := range []string{filepath.Join(dir, "a", "b.go"), filepath.Join(dir+"x", "c.go"), "d.go"} {
+		g.Reader(strings.NewReader("x\n"), name)
+	}
+	want := filepath.Join("a", "b.go") + ":x\n" + filepath.Join(dir+"x", "c.go") + ":x\nd.go:x\n"
+	if out.String() != want {
+		t.Errorf("grep with Dir %q = %q, want %q", dir, out.String(), want)
+	}
+}
+
+func TestGrepOnMatch(t *testing.T) {
+	for _, compile := range []func(string) (*Regexp, error){Compile, CompileMultiline} {
+		re, err := compile(`(?m)b+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		g := Grep{Regexp: re, Stdout: io.Discard, Stderr: io.Discard, C: true}
+		g.OnMatch = func(name string, lineno int, line []byte) {
+			got = append(got, fmt.Sprintf("%s:%d:%s", name, lineno, line))
+		}
+		g.Reader(strings.NewReader("abc\nx\nbb\n"), "input")
+		if want := []string{"input:1:abc", "input:3:bb"}; !slices.Equal(got, want) {
+			t.Errorf("OnMatch calls = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGrepStats(t *testing.T) {
+	re, err := Compile(`(?m)x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, errb bytes.Buffer
+	g := Grep{Regexp: re, Stdout: &out, Stderr: &errb, JSON: true, Stats: NewStats()}
+	g.Stats.EndPhase("parse")
+	g.Reader(strings.NewReader("x\nyx\n"), "a")
+	g.Reader(strings.NewReader("y\n"), "b")
+	g.File(filepath.Join(t.TempDir(), "missing"))
+	g.Stats.EndPhase("search")
+	g.Finish()
+
+	st := g.Stats
+	if st.Files != 2 || st.Missing != 1 || st.Bytes != 7 || st.FilesMatched != 1 || st.Matches != 2 {
+		t.Errorf("Stats = %+v, want 2 files, 1 missing, 7 bytes, 1 file matched, 2 matches", st)
+	}
+	if len(st.Phases) != 2 || st.Phases[0].Name != "parse" || st.Phases[1].Name != "search" {
+		t.Errorf("Stats.Phases = %v, want parse, search", st.Phases)
+	}
+	for _, want := range []string{"read:       2 files, 7 bytes\n", "missing:    1 file\n", "matched:    1 file, 2 matches\n"} {
+		if !strings.Contains(errb.String(), want) {
+			t.Errorf("stats output missing %q:\n%s", want, errb.String())
+		}
+	}
+	if strings.Contains(errb.String(), "query:") {
+		t.Errorf("stats output without index mentions query:\n%s", errb.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var sum struct {
+		Type string
+		Data struct {
+			Search struct {
+				QueryTrigrams *int `json:"query_trigrams"`
+				FilesRead     int  `json:"files_read"`
+				FilesMissing  int  `json:"files_missing"`
+				Matches       int
+				Phases        []struct{ Name string }
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &sum); err != nil {
+		t.Fatal(err)
+	}
+	s := sum.Data.Search
+	if sum.Type != "summary" || s.QueryTrigrams != nil || s.FilesRead != 2 || s.FilesMissing != 1 || s.Matches != 2 || len(s.Phases) != 2 {
+		t.Errorf("JSON summary = %s", lines[len(lines)-1])
+	}
+}
+
+func TestParseColors(t *testing.T) {
+	c, err := ParseColors("")
+	if err != nil || *c != DefaultColors {
+		t.Errorf("ParseColors(\"\") = %+v, %v, want %+v", c, err, DefaultColors)
+	}
+	c, err = ParseColors("match=4:sep=:path=1;35")
+	if want := (Colors{Path: "1;35", Line: "32", Match: "4"}); err != nil || *c != want {
+		t.Errorf("ParseColors = %+v, %v, want %+v", c, err, want)
+	}
+	for _, s := range []string{"match", "match=red", "col=1"} {
+		if _, err := ParseColors(s); err == nil {
+			t.Errorf("ParseColors(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestGrepEncoding(t *testing.T) {
+	re, err := Compile(`(?m)caf.$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enc charset.Map
+	enc.Set("*.c=latin1")
+	var out bytes.Buffer
+	g := Grep{Regexp: re, Stdout: &out, Stderr: &out, N: true, Encodings: &enc}
+	g.Reader(strings.NewReader("\xFF\xFEx\x00\n\x00c\x00a\x00f\x00\xE9\x00"), "a.txt")
+	g.Reader(strings.NewReader("caf\xE9\n"), "b.c")
+	g.Reader(strings.NewReader("caf\xE9\n"), "c.txt")
+	if want := "a.txt:2:café\nb.c:1:café\n"; out.String() != want {
+		t.Errorf("grep = %q, want %q", out.String(), want)
+	}
+}
+
+var grepJSONTests = []struct {
+	re    string
+	multi bool
+	s     string
+	g     Grep
+	out   string
+}{
+	{
+		re: `b+`,
+		s:  "abc\nx\nbb\n",
+		out: `{"type":"begin","data":{"path":{"text":"input"}}}
+{"type":"match","data":{"path":{"text":"input"},"lines":{"text":"abc\n"},"line_number":1,"absolute_offset":0,"submatches":[{"match":{"text":"b"},"start":1,"end":2}]}}
+{"type":"match","data":{"path":{"text":"input"},"lines":{"text":"bb\n"},"line_number":3,"absolute_offset":6,"submatches":[{"match":{"text":"bb"},"start":0,"end":2}]}}
+{"type":"end","data":{"path":{"text":"input"},"binary_offset":null,"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":9,"bytes_printed":384,"matched_lines":2,"matches":2}}}
+{"type":"summary","data":{"elapsed_total":{},"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":9,"bytes_printed":384,"matched_lines":2,"matches":2}}}
+`,
+	},
+	{
+		re: `x`,
+		s:  "1\n2\nx\n3\n4\n5\nx",
+		g:  Grep{PreContext: 1, PostContext: 2},
+		out: `{"type":"begin","data":{"path":{"text":"input"}}}
+{"type":"context","data":{"path":{"text":"input"},"lines":{"text":"2\n"},"line_number":2,"absolute_offset":2,"submatches":[]}}
+{"type":"match","data":{"path":{"text":"input"},"lines":{"text":"x\n"},"line_number":3,"absolute_offset":4,"submatches":[{"match":{"text":"x"},"start":0,"end":1}]}}
+{"type":"context","data":{"path":{"text":"input"},"lines":{"text":"3\n"},"line_number":4,"absolute_offset":6,"submatches":[]}}
+{"type":"context","data":{"path":{"text":"input"},"lines":{"text":"4\n"},"line_number":5,"absolute_offset":8,"submatches":[]}}
+{"type":"context","data":{"path":{"text":"input"},"lines":{"text":"5\n"},"line_number":6,"absolute_offset":10,"submatches":[]}}
+{"type":"match","data":{"path":{"text":"input"},"lines":{"text":"x"},"line_number":7,"absolute_offset":12,"submatches":[{"match":{"text":"x"},"start":0,"end":1}]}}
+{"type":"end","data":{"path":{"text":"input"},"binary_offset":null,"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":13,"bytes_printed":888,"matched_lines":2,"matches":2}}}
+{"type":"summary","data":{"elapsed_total":{},"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":13,"bytes_printed":888,"matched_lines":2,"matches":2}}}
+`,
+	},
+	{
+		re: `a`,
+		s:  "\xffa\n",
+		out: `{"type":"begin","data":{"path":{"text":"input"}}}
+{"type":"match","data":{"path":{"text":"input"},"lines":{"bytes":"/2EK"},"line_number":1,"absolute_offset":0,"submatches":[{"match":{"text":"a"},"start":1,"end":2}]}}
+{"type":"end","data":{"path":{"text":"input"},"binary_offset":null,"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":3,"bytes_printed":217,"matched_lines":1,"matches":1}}}
+{"type":"summary","data":{"elapsed_total":{},"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":3,"bytes_printed":217,"matched_lines":1,"matches":1}}}
+`,
+	},
+	{
+		re:    `a\nb|c`,
+		multi: true,
+		s:     "xa\nbc\nd\n",
+		out: `{"type":"begin","data":{"path":{"text":"input"}}}
+{"type":"match","data":{"path":{"text":"input"},"lines":{"text":"xa\nbc\n"},"line_number":1,"absolute_offset":0,"submatches":[{"match":{"text":"a\nb"},"start":1,"end":4},{"match":{"text":"c"},"start":4,"end":5}]}}
+{"type":"end","data":{"path":{"text":"input"},"binary_offset":null,"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":8,"bytes_printed":264,"matched_lines":2,"matches":2}}}
+{"type":"summary","data":{"elapsed_total":{},"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":8,"bytes_printed":264,"matched_lines":2,"matches":2}}}
+`,
+	},
+	{
+		re: `q`,
+		s:  "abc\n",
+		out: `{"type":"summary","data":{"elapsed_total":{},"stats":{"elapsed":{},"searches":1,"searches_with_match":0,"bytes_searched":4,"bytes_printed":0,"matched_lines":0,"matches":0}}}
+`,
+	},
+}
+
+func TestGrepJSON(t *testing.T) {
+	elapsed := stdregexp.MustCompile(`("elapsed(_total)?"):\{[^}]*\}`)
+	for i, tt := range grepJSONTests {
+		compile := Compile
+		if tt.multi {
+			compile = CompileMultiline
+		}
+		re, err := compile("(?m)" + tt.re)
+		if err != nil {
+			t.Errorf("Compile(%#q): %v", tt.re, err)
+			continue
+		}
+		g := tt.g
+		g.Regexp = re
+		g.JSON = true
+		var out, errb bytes.Buffer
+		g.Stdout = &out
+		g.Stderr = &errb
+		g.Reader(strings.NewReader(tt.s), "input")
+		g.Finish()
+		if s := elapsed.ReplaceAllString(out.String(), "$1:{}"); s != tt.out || errb.Len() != 0 {
+			t.Errorf("#%d: grep -json %#q %q = %s%s, want %s", i, tt.re, tt.s, s, errb.String(), tt.out)
+		}
+	}
+}
+
+func TestGrepJSONList(t *testing.T) {
+	var out bytes.Buffer
+	g := &Grep{Stdout: &out, Stderr: &out, JSON: true}
+	g.List("a.go")
+	want := `{"type":"begin","data":{"path":{"text":"a.go"}}}
+{"type":"end","data":{"path":{"text":"a.go"},"binary_offset":null,"stats":{"elapsed":{},"searches":1,"searches_with_match":1,"bytes_searched":0,"bytes_printed":49,"matched_lines":0,"matches":0}}}
+`
+	elapsed := stdregexp.MustCompile(`("elapsed"):\{[^}]*\}`)
+	if s := elapsed.ReplaceAllString(out.String(), "$1:{}"); s != want {
+		t.Errorf("List(a.go) in JSON mode = %s, want %s", s, want)
+	}
+}
+
+func TestGrepOnLines(t *testing.T) {
+	re, err := Compile(`(?m)b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	var got []Lines
+	g := &Grep{Regexp: re, Stdout: &out, Stderr: &out, PreContext: 1, PostContext: 1}
+	g.OnLines = func(l *Lines) {
+		l1 := *l
+		l1.Text = bytes.Clone(l.Text)
+		got = append(got, l1)
+	}
+	g.Reader(strings.NewReader("a\nb\nc\n"), "input")
+	g.List("x")
+	g.Finish()
+	want := []Lines{
+		{Name: "input", Context: true, Lineno: 1, Offset: 0, Text: []byte("a\n")},
+		{Name: "input", Lineno: 2, Offset: 2, Text: []byte("b\n"), Submatches: [][]int{{0, 1}}},
+		{Name: "input", Context: true, Lineno: 3, Offset: 4, Text: []byte("c\n")},
+		{Name: "x"},
+	}
+	if !reflect.DeepEqual(got, want) || out.Len() != 0 {
+		t.Errorf("OnLines got %+v, output %q; want %+v and no output", got, out.String(), want)
+	}
+}
+
+func TestGrepLimits(t *testing.T) {
+	re, err := Compile(`(?m)a+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const input = "abc\ndef\nghalloo\n"
+
+	var out, errb bytes.Buffer
+	g := Grep{Regexp: re, Stdout: &out, Stderr: &errb, MaxBytes: 5}
+	err = g.ReaderContext(context.Background(), strings.NewReader(input), "input")
+	g.Finish()
+	if err != ErrMaxBytes || g.Incomplete != ErrMaxBytes || g.Bytes != 5 {
+		t.Errorf("MaxBytes: err=%v Incomplete=%v Bytes=%d, want %v, %v, 5", err, g.Incomplete, g.Bytes, ErrMaxBytes, ErrMaxBytes)
+	}
+	if want := "input:abc\n"; out.String() != want {
+		t.Errorf("MaxBytes: output %q, want %q", out.String(), want)
+	}
+	if want := "results incomplete: byte limit reached\n"; errb.String() != want {
+		t.Errorf("MaxBytes: stderr %q, want %q", errb.String(), want)
+	}
+
+	out.Reset()
+	errb.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g = Grep{Regexp: re, Stdout: &out, Stderr: &errb, HTML: true}
+	err = g.ReaderContext(ctx, strings.NewReader(input), "input")
+	g.Finish()
+	if err != context.Canceled || out.String() != "<p class=\"incomplete\">results incomplete: context canceled</p>\n" || errb.Len() != 0 {
+		t.Errorf("canceled: err=%v, output %q, stderr %q", err, out.String(), errb.String())
+	}
+
+	out.Reset()
+	g = Grep{Regexp: re, Stdout: &out, Stderr: &errb}
+	if err := g.ReaderContext(context.Background(), strings.NewReader(input), "input"); err != nil || g.Incomplete != nil {
+		t.Errorf("no limit: err=%v Incomplete=%v", err, g.Incomplete)
+	}
+	// Match limits.
+	limitTests := []struct {
+		g      Grep
+		multi  bool
+		out    string
+		stderr string
+	}{
+		{g: Grep{FileLimit: 1}, out: "a:abc\nb:abc\n", stderr: "stopped reading 2 files after 1 match\n"},
+		{g: Grep{FileLimit: 2}, out: "a:abc\na:ghalloo\nb:abc\nb:ghalloo\n"},
+		{g: Grep{FileLimit: 1, C: true}, out: "a: 1\nb: 1\n", stderr: "stopped reading 2 files after 1 match\n"},
+		{g: Grep{FileLimit: 1, PostContext: 1}, out: "a:abc\na-def\n--\nb:abc\nb-def\n", stderr: "stopped reading 2 files after 1 match\n"},
+		{g: Grep{Limit: 3}, out: "a:abc\na:ghalloo\nb:abc\n", stderr: "results incomplete: stopped after 3 matches\n"},
+		{g: Grep{Limit: 4}, out: "a:abc\na:ghalloo\nb:abc\nb:ghalloo\n"},
+		{g: Grep{FileLimit: 1}, multi: true, out: "a:abc\nb:abc\n", stderr: "stopped reading 2 files after 1 match\n"},
+		{g: Grep{FileLimit: 1, Limit: 1}, out: "a:abc\n", stderr: "results incomplete: stopped after 1 match\n"},
+		{g: Grep{FileLimit: 1, Limit: 2}, out: "a:abc\nb:abc\n", stderr: "stopped reading 1 file after 1 match\nresults incomplete: stopped after 2 matches\n"},
+		{g: Grep{Limit: 1, C: true}, multi: true, out: "a: 1\n", stderr: "results incomplete: stopped after 1 match\n"},
+	}
+	for i, tt := range limitTests {
+		out.Reset()
+		errb.Reset()
+		g := tt.g
+		g.Regexp = re
+		if tt.multi {
+			g.Regexp, _ = CompileMultiline(re.String())
+		}
+		g.Stdout = &out
+		g.Stderr = &errb
+		for _, name := range []string{"a", "b"} {
+			if g.Limited {
+				break
+			}
+			g.Reader(strings.NewReader(input), name)
+		}
+		g.Finish()
+		if out.String() != tt.out || errb.String() != tt.stderr {
+			t.Errorf("#%d: limits %+v: output %q, stderr %q, want %q, %q", i, tt.g, out.String(), errb.String(), tt.out, tt.stderr)
+		}
+	}
+
+	out.Reset()
+	errb.Reset()
+	g = Grep{Regexp: re, Stdout: &out, Stderr: &errb, FileLimit: 1, JSON: true}
+	g.Reader(strings.NewReader(input), "a")
+	g.Finish()
+	if s := out.String(); !strings.Contains(s, `"limited":true`) || strings.Contains(s, `"incomplete"`) || errb.String() != "stopped reading 1 file after 1 match\n" {
+		t.Errorf("JSON output does not note per-file limit in end event and on stderr:\n%s%s", s, errb.String())
+	}
+}
+
+func TestConcurrentMatch(t *testing.T) {
+	re, err := Compile(`(?m)(\w+)@(\w+)\.com`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re.SetCacheLimit(0) // exercise flushes too
+	text := []byte(strings.Repeat("mail a@b.com or c@d.org\nnothing here\n", 100))
+	wantLines := fmt.Sprint(grep(re, text))
+	wantFind := fmt.Sprint(re.FindAllIndex(text, -1))
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if g%2 == 1 {
+					re.SetCacheLimit(DefaultCacheLimit)
+				}
+				if lines := fmt.Sprint(grep(re, text)); lines != wantLines {
+					t.Errorf("concurrent grep = %s, want %s", lines, wantLines)
+					return
+				}
+				if m := re.MatchString("x@y.com", true, true); m != 7 {
+					t.Errorf("concurrent MatchString = %d, want 7", m)
+					return
+				}
+				if find := fmt.Sprint(re.FindAllIndex(text, -1)); find != wantFind {
+					t.Errorf("concurrent FindAllIndex = %s, want %s", find, wantFind)
+					return
+				}
+				re.CacheStats()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMachineReuse(t *testing.T) {
+	re, err := Compile(`(?m)a+b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := re.get()
+	re.put(mc)
+	runtime.GC()
+	if mc1 := re.get(); mc1 != mc {
+		t.Errorf("get after GC returned a new machine")
+	}
+}
+
+var benchText = []byte(strings.Repeat("mail a@b.com or c@d.org\nnothing here\n"+strings.Repeat("just some text without the pattern\n", 8), 10000))
+
+func BenchmarkMatch(b *testing.B) {
+	re, err := Compile(`(?m)(\w+)@(\w+)\.com`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	line := []byte("just some text without the pattern, but long enough to take time")
+	b.SetBytes(int64(len(line)))
+	for range b.N {
+		re.Match(line, true, true)
+	}
+}
+
+func BenchmarkMatchParallel(b *testing.B) {
+	re, err := Compile(`(?m)(\w+)@(\w+)\.com`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	line := []byte("just some text without the pattern, but long enough to take time")
+	b.SetBytes(int64(len(line)))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			re.Match(line, true, true)
+		}
+	})
+}
+
+func BenchmarkGrep(b *testing.B) {
+	re, err := Compile(`(?m)(\w+)@(\w+)\.com`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, column := range []bool{false, true} {
+		b.Run(fmt.Sprintf("column=%v", column), func(b *testing.B) {
+			g := &Grep{Regexp: re, Stdout: io.Discard, Stderr: io.Discard, N: true, Column: column}
+			b.SetBytes(int64(len(benchText)))
+			for range b.N {
+				g.Reader(bytes.NewReader(benchText), "input")
+			}
+		})
+	}
+}
+
+var findTests = []struct {
+	re  string
+	s   string
+	n   int
+	out string
+}{
+	{`a+`, "xaay\nab\n", -1, "[[1 3] [5 6]]"},
+	{`a+`, "xaay\nab\n", 1, "[[1 3]]"},
+	{`ab|abc`, "abcd", -1, "[[0 3]]"},
+	{`(a|ab)(c|bcd)`, "abcd", -1, "[[0 4]]"},
+	{`x*`, "axb", -1, "[[0 0] [1 2] [3 3]]"},
+	{`\bfoo\b`, "foo foobar barfoo foo", -1, "[[0 3] [18 21]]"},
+	{`^x|y$`, "xay\nyx\n", -1, "[[0 1] [2 3]]"},
+	{`(?i)go+`, "Go gOO\nno", -1, "[[0 2] [3 6]]"},
+	{`ç+`, "aççb", -1, "[[1 5]]"},
+	{`a.b`, "a\nb", -1, "[]"},
+	{`q`, "abc", -1, "[]"},
+}
+
+func TestFindAllIndex(t *testing.T) {
+	for _, tt := range findTests {
+		re, err := Compile("(?m)" + tt.re)
+		if err != nil {
+			t.Errorf("Compile(%#q): %v", tt.re, err)
+			continue
+		}
+		out := fmt.Sprint(re.FindAllIndex([]byte(tt.s), tt.n))
+		if out != tt.out {
+			t.Errorf("FindAllIndex(%#q, %q, %d) = %s, want %s", tt.re, tt.s, tt.n, out, tt.out)
+		}
+	}
+}
+
+// TestFindAllIndexStd checks FindAllIndex against the standard
+// library's leftmost-longest matching on random inputs.
+func TestFindAllIndexStd(t *testing.T) {
+	pats := []string{
+		`a+`, `a*`, `ab|abc`, `\bfoo\b`, `^x`, `y$`, `(a|ab)(c|bcd)`, `[a-c]+d?`,
+		`(?i)AB`, `x*y*`, `\B`, `\b`, `.`, `..`, `a.*c`, `ç+`, `(a|b)*c`, `^$`, `$`, `^`,
+	}
+	alpha := []string{"a", "b", "c", "d", "x", "y", " ", "f", "o", "ç", "\n"}
+	r := rand.New(rand.NewSource(1))
+	for _, pat := range pats {
+		re, err := Compile("(?m)" + pat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		std := stdregexp.MustCompile("(?m)" + pat)
+		std.Longest()
+		for range 1000 {
+			var b []byte
+			for range r.Intn(12) {
+				b = append(b, alpha[r.Intn(len(alpha))]...)
+			}
+			out := fmt.Sprint(re.FindAllIndex(b, -1))
+			want := fmt.Sprint(std.FindAllIndex(b, -1))
+			if out != want {
+				t.Errorf("FindAllIndex(%#q, %q) = %s, want %s", pat, b, out, want)
+				break
+			}
+		}
+	}
+}
+
+// TestFindAllIndexMultiline checks FindAllIndex in multiline mode
+// against the standard library on random inputs.
+func TestFindAllIndexMultiline(t *testing.T) {
+	pats := []string{
+		`a\nb`, `(?s)a.*b`, `a.*b`, `a\s+b`, `\n+`, `x$\n^y`, `[^a]+`, `(?s).`,
+		`a+`, `\bfoo\b`, `^$`, `(a|\n)*c`, `\Ax|y\z`,
+	}
+	alpha := []string{"a", "b", "c", "x", "y", " ", "f", "o", "ç", "\n", "\n"}
+	r := rand.New(rand.NewSource(1))
+	for _, pat := range pats {
+		re, err := CompileMultiline("(?m)" + pat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		std := stdregexp.MustCompile("(?m)" + pat)
+		std.Longest()
+		for range 1000 {
+			var b []byte
+			for range r.Intn(12) {
+				b = append(b, alpha[r.Intn(len(alpha))]...)
+			}
+			out := fmt.Sprint(re.FindAllIndex(b, -1))
+			want := fmt.Sprint(std.FindAllIndex(b, -1))
+			if out != want {
+				t.Errorf("FindAllIndex(%#q, %q) = %s, want %s", pat, b, out, want)
+				break
+			}
+			if m, want := re.Match(b, true, true) >= 0, std.Match(b); m != want {
+				t.Errorf("Match(%#q, %q) = %v, want %v", pat, b, m, want)
+				break
+			}
+		}
+	}
+}
+
+var grepMultilineTests = []struct {
+	re  string
+	s   string
+	out string
+	g   Grep
+}{
+	{re: `foo\nbar`, s: "x\nfoo\nbar\ny\n", out: "input:foo\ninput:bar\n"},
+	{re: `o\nb`, s: "x\nfoo\nbar\ny\n", g: Grep{N: true}, out: "input:2:foo\ninput:3:bar\n"},
+	{re: `foo.bar`, s: "foo\nbar\n", out: ""},
+	{re: `(?s)\{.*?\}`, s: "f() {\n}\ng() {\n\tx\n}\n", g: Grep{N: true, H: true}, out: "1:f() {\n2:}\n3:g() {\n4:\tx\n5:}\n"},
+	{re: `a\nb|b\nc`, s: "a\nb b\nc\nd", g: Grep{N: true}, out: "input:1:a\ninput:2:b b\ninput:3:c\n"},
+	{re: `a\nb`, s: "a\nb\na\nb", g: Grep{C: true}, out: "input: 2\n"},
+	{re: `a\nb`, s: "xa\nb\n", g: Grep{O: true, N: true}, out: "input:1:a\nb\n"},
+	{re: `d$`, s: "a\nb\nc\nd", g: Grep{N: true}, out: "input:4:d\n"},
+	{re: `a\nb`, s: "a\nb\n", g: Grep{L: true}, out: "input\n"},
+	{re: `a\nb|c`, s: "xa\nbc\n", g: Grep{H: true, Color: testColors}, out: "x\x1b[3ma\x1b[0m\n\x1b[3mb\x1b[0m\x1b[3mc\x1b[0m\n"},
+	{re: `a\nb`, s: "xa\nbc\n", g: Grep{Column: true}, out: "input:1:2:xa\ninput:2:1:bc\n"},
+}
+
+func TestGrepMultiline(t *testing.T) {
+	for i, tt := range grepMultilineTests {
+		re, err := CompileMultiline("(?m)" + tt.re)
+		if err != nil {
+			t.Errorf("CompileMultiline(%#q): %v", tt.re, err)
+			continue
+		}
+		g := tt.g
+		g.Regexp = re
+		var out, errb bytes.Buffer
+		g.Stdout = &out
+		g.Stderr = &errb
+		g.Reader(strings.NewReader(tt.s), "input")
+		if out.String() != tt.out || errb.Len() != 0 {
+			t.Errorf("#%d: grep(%#q, %q) = %q, %q, want %q", i, tt.re, tt.s, out.String(), errb.String(), tt.out)
+		}
+	}
+}
+
+var replaceTests = []struct {
+	re    string
+	multi bool
+	tmpl  string
+	s     string
+	out   string
+}{
+	{re: `Foo(\w*)`, tmpl: "Bar$1", s: "Foo FooX\nx := Foos()\n", out: "Bar BarX\nx := Bars()\n"},
+	{re: `(\w+)\.(\w+)`, tmpl: "${2}_$1", s: "a.b c.d\n", out: "b_a d_c\n"},
+	{re: `(?P<key>\w+)=(?P<val>\w+)`, tmpl: "${val}=${key}$$", s: "x=1\ny=2", out: "1=x$\n2=y$"},
+	{re: `a\s+`, tmpl: "a", s: "a  \nb a\n", out: "a\nb a\n"},
+	{re: `^$`, tmpl: "-", s: "a\n\nb\n", out: "a\n-\nb\n"},
+	{re: `x$`, tmpl: "y", s: "ax\nbx", out: "ay\nby"},
+	{re: `a+`, tmpl: "b", s: "caaat\n", out: "cbt\n"},
+	{re: `a\nb`, tmpl: "ab", s: "a\nb\n", out: "a\nb\n"},
+	{re: `a\nb`, multi: true, tmpl: "ab", s: "xa\nb\na\nb", out: "xab\nab"},
+	{re: `(?s)\{.*?\}`, multi: true, tmpl: "{}", s: "f() {\n\tx\n}\n", out: "f() {}\n"},
+	{re: `nomatch`, tmpl: "x", s: "a\nb\n", out: "a\nb\n"},
+}
+
+func TestReplacements(t *testing.T) {
+	for i, tt := range replaceTests {
+		compile := Compile
+		if tt.multi {
+			compile = CompileMultiline
+		}
+		re, err := compile("(?m)" + tt.re)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edits, err := re.Replacements([]byte(tt.s), tt.tmpl)
+		if err != nil {
+			t.Errorf("#%d: Replacements(%#q, %q): %v", i, tt.re, tt.tmpl, err)
+			continue
+		}
+		for j := 1; j < len(edits); j++ {
+			if edits[j].Start < edits[j-1].End {
+				t.Errorf("#%d: Replacements(%#q, %q): overlapping edits %v", i, tt.re, tt.tmpl, edits)
+			}
+		}
+		if out := string(Apply([]byte(tt.s), edits)); out != tt.out {
+			t.Errorf("#%d: replace(%#q, %q, %q) = %q, want %q", i, tt.re, tt.tmpl, tt.s, out, tt.out)
+		}
+	}
+
+	re, err := CompileFuzzy("hello", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := re.Replacements([]byte("hello\n"), "x"); err == nil {
+		t.Errorf("Replacements with fuzzy regexp succeeded, want error")
+	}
+}
+
+var prefilterTests = []struct {
+	re    string
+	lit   string
+	first byte
+}{
+	{re: `hello`, lit: "hello"},
+	{re: `\w+Handler\(`, lit: "Handler("},
+	{re: `func (\w+)\(\) error`, lit: "() error"},
+	{re: `(abc|abd)x+`, lit: "ab"},
+	{re: `(?i)foo`},
+	{re: `^\s*#include`, lit: "#include"},
+	{re: `[xy]z*|q`},
+	{re: `x+|xy`, first: 'x'},
+	{re: `\bint(8|16)?\b`, lit: "int"},
+	{re: `(?i)kelvin`}, // K folds to U+212A KELVIN SIGN
+	{re: `a*b`, lit: "b"},
+	{re: `a*(b|c)`},
+	{re: `a*`},
+	{re: `[a-z]+`},
+	{re: `é+`, lit: "é"},
+	{re: `[é-ë]`, first: 0xc3},
+	{re: `.x`, lit: "x"},
+	{re: `.[xy]`},
+}
+
+func TestPrefilter(t *testing.T) {
+	for _, tt := range prefilterTests {
+		re, err := Compile("(?m)" + tt.re)
+		if err != nil {
+			t.Errorf("Compile(%#q): %v", tt.re, err)
+			continue
+		}
+		var lit string
+		var first byte
+		if re.pre != nil {
+			lit, first = string(re.pre.lit), re.pre.first
+		}
+		if lit != tt.lit || first != tt.first {
+			t.Errorf("prefilter(%#q) = %q, %q, want %q, %q", tt.re, lit, first, tt.lit, tt.first)
+		}
+	}
+}
+
+// TestGrepStd checks the lines Grep prints, with and without prefilters,
+// against the standard library on random inputs.
+func TestGrepStd(t *testing.T) {
+	pats := []string{
+		`ab`, `a+b`, `^ab`, `b$`, `\bab\b`, `(?i)ab`, `(ab|c)d?`, `x*y`,
+		`\Aa`, `b\z`, `[ab]c`, `ç`, `a.c`,
+	}
+	alpha := []string{"a", "b", "c", "d", "x", "y", " ", "A", "B", "ç", "\n", "\n"}
+	r := rand.New(rand.NewSource(1))
+	for _, pat := range pats {
+		re, err := Compile("(?m)" + pat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		std := stdregexp.MustCompile("(?m)" + pat)
+		var out bytes.Buffer
+		g := Grep{Regexp: re, Stdout: &out, Stderr: &out, N: true, H: true}
+		for range 1000 {
+			var b []byte
+			for range r.Intn(20) {
+				b = append(b, alpha[r.Intn(len(alpha))]...)
+			}
+			var want bytes.Buffer
+			lines := bytes.SplitAfter(b, nl)
+			pos := 0
+			for i, line := range lines {
+				if len(line) == 0 {
+					continue
+				}
+				end := pos + len(bytes.TrimSuffix(line, nl))
+				for _, m := range std.FindAllIndex(b, -1) {
+					if pos <= m[0] && m[1] <= end {
+						fmt.Fprintf(&want, "%d:%s", i+1, line)
+						if line[len(line)-1] != '\n' {
+							want.WriteString("\n")
+						}
+						break
+					}
+				}
+				pos += len(line)
+			}
+			out.Reset()
+			g.Reader(bytes.NewReader(b), "input")
+			if out.String() != want.String() {
+				t.Errorf("Grep(%#q, %q) = %q, want %q", pat, b, out.String(), want.String())
+				break
+			}
+		}
+	}
+}
+
+// fuzzyDist returns the least Levenshtein distance between lit
+// and any substring of line.
+func fuzzyDist(lit, line []rune) int {
+	// d[j] is the distance between lit[:i] and the best substring
+	// of line ending at j.
+	d := make([]int, len(line)+1)
+	for i := 1; i <= len(lit); i++ {
+		prev := d[0] // d[i-1][j-1]
+		d[0] = i
+		for j := 1; j <= len(line); j++ {
+			cost := 1
+			if lit[i-1] == line[j-1] {
+				cost = 0
+			}
+			prev, d[j] = d[j], min(prev+cost, d[j]+1, d[j-1]+1)
+		}
+	}
+	return slices.Min(d)
+}
+
+func TestFuzzy(t *testing.T) {
+	lits := []string{"ab", "abc", "hello", "aaa", "abcabc", "çaç", "Receive"}
+	alpha := []string{"a", "b", "c", "ç", "h", "e", "l", "o", "R", "i", "v", "\n"}
+	r := rand.New(rand.NewSource(1))
+	for _, lit := range lits {
+		for k := range min(3, utf8.RuneCountInString(lit)) {
+			re, err := CompileFuzzy(lit, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 300 {
+				var b []byte
+				for range r.Intn(15) {
+					b = append(b, alpha[r.Intn(len(alpha))]...)
+				}
+				var want []int
+				for i, line := range bytes.Split(b, nl) {
+					if fuzzyDist([]rune(lit), []rune(string(line))) <= k {
+						want = append(want, i+1)
+					}
+				}
+				if lines := grep(re, b); !slices.Equal(lines, want) {
+					t.Errorf("fuzzy %d grep(%q, %q) = %v, want %v", k, lit, b, lines, want)
+					break
+				}
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		lit string
+		k   int
+	}{
+		{"ab", 2}, {"a\nb", 1}, {"x", -1}, {strings.Repeat("x", 65), 1},
+	} {
+		if _, err := CompileFuzzy(tt.lit, tt.k); err == nil {
+			t.Errorf("CompileFuzzy(%q, %d) succeeded, want error", tt.lit, tt.k)
+		}
+	}
+}
+
+var excludeTests = []struct {
+	regexps []string
+	globs   []string
+	name    string
+	want    bool
+}{
+	{globs: []string{"*_test.go"}, name: "/src/x/y_test.go", want: true},
+	{globs: []string{"*_test.go"}, name: "/src/x/y.go", want: false},
+	{globs: []string{"vendor"}, name: "/src/vendor/a/b.go", want: true},
+	{globs: []string{"vendor/"}, name: "/src/vendor/a/b.go", want: true},
+	{globs: []string{"vendor"}, name: "/src/vendored/b.go", want: false},
+	{globs: []string{"third_party/go"}, name: "/src/third_party/go/x.go", want: true},
+	{globs: []string{"third_party/go"}, name: "/src/third_party/c/go", want: false},
+	{globs: []string{"*.txt"}, name: "/src/a.zip\x01doc/x.txt", want: true},
+	{regexps: []string{`_test\.go$`}, name: "/src/x/y_test.go", want: true},
+	{regexps: []string{`_test\.go$`, `^/gen/`}, name: "/gen/y.go", want: true},
+	{regexps: []string{`_test\.go$`}, globs: []string{"vendor"}, name: "/src/y.go", want: false},
+}
+
+func TestExclude(t *testing.T) {
+	for _, tt := range excludeTests {
+		var x Exclude
+		for _, re := range tt.regexps {
+			if err := x.AddRegexp(re); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, glob := range tt.globs {
+			if err := x.AddGlob(glob); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if m := x.Match(tt.name); m != tt.want {
+			t.Errorf("Exclude{%q, %q}.Match(%q) = %v, want %v", tt.regexps, tt.globs, tt.name, m, tt.want)
+		}
+	}
+}
+
+func TestExcludeFlags(t *testing.T) {
+	var x Exclude
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	x.addFlags(fs)
+	if err := fs.Parse([]string{"-exclude", "vendor", "-exclude-regexp", `_test\.go$`}); err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Globs) != 1 || len(x.Regexps) != 1 {
+		t.Errorf("after flags, Exclude has %d globs and %d regexps, want 1 and 1", len(x.Globs), len(x.Regexps))
+	}
+	// -x means a whole-line match in grep; it must not be taken to exclude files.
+	if err := fs.Parse([]string{"-x", "foo"}); err == nil {
+		t.Errorf("-x flag is defined")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"bytes"
+	"errors"
+	stdregexp "regexp"
+)
+
+// The DFA and the Pike VM in find.go do not track submatches,
+// so replacement finds the lines containing matches with the DFA
+// and then expands the template using the standard regexp package,
+// in leftmost-longest mode, on each of those lines.
+
+// An Edit replaces the bytes Start through End of a text with New.
+type Edit struct {
+	Start, End int
+	New        []byte
+}
+
+// Replacements returns the edits that replace each match of r in b
+// with template, in which $1, ${1}, and ${name} stand for submatches,
+// as in the standard regexp package's Expand.
+// As in FindAllIndex, matches are leftmost-longest and, unless r was
+// compiled by CompileMultiline, cannot span lines.
+// The edits are in order and do not overlap.
+// Replacements returns an error for a Regexp made by CompileFuzzy.
+func (r *Regexp) Replacements(b []byte, template string) ([]Edit, error) {
+	std, err := r.stdRegexp()
+	if err != nil {
+		return nil, err
+	}
+	if r.multi {
+		if r.Match(b, true, true) < 0 {
+			return nil, nil
+		}
+		return appendEdits(nil, std, b, 0, template), nil
+	}
+	// As in grep, there is no empty line after a final newline,
+	// nor any line at all in empty text.
+	if len(b) == 0 {
+		return nil, nil
+	}
+	text := bytes.TrimSuffix(b, nl)
+	var edits []Edit
+	for pos := 0; pos <= len(text); {
+		e := r.Match(text[pos:], pos == 0, true)
+		if e < 0 {
+			break
+		}
+		end := pos + e
+		start := pos + bytes.LastIndexByte(b[pos:end], '\n') + 1
+		edits = appendEdits(edits, std, b[start:end], start, template)
+		pos = end + 1
+	}
+	return edits, nil
+}
+
+// appendEdits appends to edits the replacements of the matches of std
+// in text, which is at offset off in the whole text.
+func appendEdits(edits []Edit, std *stdregexp.Regexp, text []byte, off int, template string) []Edit {
+	for _, m := range std.FindAllSubmatchIndex(text, -1) {
+		edits = append(edits, Edit{
+			Start: off + m[0],
+			End:   off + m[1],
+			New:   std.Expand(nil, []byte(template), text, m),
+		})
+	}
+	return edits
+}
+
+// stdRegexp returns r compiled by the standard regexp package.
+func (r *Regexp) stdRegexp() (*stdregexp.Regexp, error) {
+	if r.fuzzy != nil {
+		return nil, errors.New("cannot replace approximate matches")
+	}
+	r.stdOnce.Do(func() {
+		r.std, r.stdErr = stdregexp.Compile(r.expr)
+		if r.std != nil {
+			r.std.Longest()
+		}
+	})
+	return r.std, r.stdErr
+}
+
+// Apply returns a copy of b with the edits, which must be
+// in order and not overlap, applied.
+func Apply(b []byte, edits []Edit) []byte {
+	var out []byte
+	pos := 0
+	for _, e := range edits {
+		out = append(out, b[pos:e.Start]...)
+		out = append(out, e.New...)
+		pos = e.End
+	}
+	return append(out, b[pos:]...)
+}
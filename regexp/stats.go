@@ -0,0 +1,91 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Stats records the work done by a search, for the -stats flag.
+// The caller fills in the fields about the index and ends each
+// phase of the search; Finish fills in the counts kept by the Grep
+// and prints the statistics.
+type Stats struct {
+	Indexed    bool // the search used an index
+	QuerySize  int  // trigrams in the index query
+	Candidates int  // candidate files the index returned
+
+	Files        int   // files read
+	Missing      int   // files that could not be opened
+	Bytes        int64 // bytes read
+	FilesMatched int   // files with matches
+	Matches      int   // matches
+
+	Phases []Phase       // elapsed time of each phase, in order
+	Total  time.Duration // elapsed time of the whole search
+
+	start, last time.Time
+}
+
+// A Phase is a named part of a search and the time it took.
+type Phase struct {
+	Name    string
+	Elapsed time.Duration
+}
+
+// NewStats returns a new Stats whose first phase starts now.
+func NewStats() *Stats {
+	now := time.Now()
+	return &Stats{start: now, last: now}
+}
+
+// EndPhase ends the current phase, giving it a name,
+// and starts the next one.
+func (s *Stats) EndPhase(name string) {
+	now := time.Now()
+	s.Phases = append(s.Phases, Phase{name, now.Sub(s.last)})
+	s.last = now
+}
+
+// finish records the counts kept by g and the total time.
+func (s *Stats) finish(g *Grep) {
+	s.Files = g.Files
+	s.Missing = g.Missing
+	s.Bytes = g.Bytes
+	s.FilesMatched = g.FilesMatched
+	s.Matches = g.Matches
+	s.Total = time.Since(s.start)
+}
+
+// print prints s to w, one statistic per line.
+func (s *Stats) print(w io.Writer) {
+	if s.Indexed {
+		fmt.Fprintf(w, "query:      %d %s\n", s.QuerySize, plural(s.QuerySize, "trigram", "trigrams"))
+		fmt.Fprintf(w, "candidates: %d %s\n", s.Candidates, plural(s.Candidates, "file", "files"))
+	}
+	fmt.Fprintf(w, "read:       %d %s, %d bytes\n", s.Files, plural(s.Files, "file", "files"), s.Bytes)
+	fmt.Fprintf(w, "missing:    %d %s\n", s.Missing, plural(s.Missing, "file", "files"))
+	fmt.Fprintf(w, "matched:    %d %s, %d %s\n", s.FilesMatched, plural(s.FilesMatched, "file", "files"), s.Matches, plural(s.Matches, "match", "matches"))
+	var times []string
+	for _, p := range s.Phases {
+		times = append(times, p.Name+" "+roundDuration(p.Elapsed).String())
+	}
+	times = append(times, "total "+roundDuration(s.Total).String())
+	fmt.Fprintf(w, "time:       %s\n", strings.Join(times, ", "))
+}
+
+// roundDuration rounds d to a precision suitable for printing.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond)
+	}
+	return d
+}
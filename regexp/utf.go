@@ -18,7 +18,11 @@ const (
 	argFold = 1 << 16
 )
 
-func toByteProg(prog *syntax.Prog) error {
+// toByteProg rewrites prog to match bytes instead of runes.
+// If multiline is set, the program must match \n only where
+// the original does; otherwise matching is line-at-a-time and
+// \n never reaches the program.
+func toByteProg(prog *syntax.Prog, multiline bool) error {
 	var b runeBuilder
 	for pc := range prog.Inst {
 		i := &prog.Inst[pc]
@@ -58,10 +62,18 @@ func toByteProg(prog *syntax.Prog) error {
 				}
 			}
 
-		case syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
-			// All runes.
+		case syntax.InstRuneAnyNotNL:
+			if multiline {
+				b.init(prog, uint32(pc), i.Out)
+				b.addRange(0, '\n'-1, false)
+				b.addRange('\n'+1, unicode.MaxRune, false)
+				break
+			}
 			// AnyNotNL should exclude \n but the line-at-a-time
 			// execution takes care of that for us.
+			fallthrough
+		case syntax.InstRuneAny:
+			// All runes.
 			b.init(prog, uint32(pc), i.Out)
 			b.addRange(0, unicode.MaxRune, false)
 		}